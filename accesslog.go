@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/sync2/atomic2"
+)
+
+/*
+	access log: audit trail of commands, disabled if access_log_file is empty
+	1.json lines written to access_log_file.2006-01-02 (daily) or access_log_file.2006-01-02-15 (hourly)
+	2.commands in access_log_exclude are skipped, only access_log_include are logged if it is not empty
+	3.access_log_sample_rate of the remaining commands are logged
+	4.entries are written in background, if the writer falls behind accessLogMaxPending entries,
+	  sessions wait for it when access_log_block is true, otherwise entries are dropped and counted
+	  by ssawproxy_access_log_dropped_total
+	arguments other than keys are never logged, keys are truncated like slowlog
+ */
+
+const (
+	accessLogMaxPending = 4096
+	accessLogMaxKeys    = 16
+)
+
+var accessLogRollings = map[string]log.RollingFormat{
+	"daily":  log.DailyRolling,
+	"hourly": log.HourlyRolling,
+}
+
+type accessLogEntry struct {
+	Time     string   `json:"time"`
+	Client   string   `json:"client"`
+	User     string   `json:"user"`
+	Database int      `json:"db"`
+	Cmd      string   `json:"cmd"`
+	Keys     []string `json:"keys,omitempty"`
+	Reply    string   `json:"reply"`           // string, error, int, bulkbytes or array
+	Error    string   `json:"error,omitempty"` // error reply, truncated
+	Latency  int64    `json:"latency"`         // microseconds from decode to reply flush
+}
+
+type accessLog struct {
+	out     io.WriteCloser
+	include map[string]bool
+	exclude map[string]bool
+	rate    float64
+	block   bool
+
+	entries chan *accessLogEntry
+	dropped atomic2.Int64
+
+	exit struct {
+		C chan struct{}
+	}
+	done chan struct{}
+}
+
+func newAccessLog(config *Config) (*accessLog, error) {
+	out, err := log.NewRollingFile(config.AccessLogFile, accessLogRollings[config.AccessLogRolling])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	l := &accessLog{
+		out:     out,
+		include: make(map[string]bool),
+		exclude: make(map[string]bool),
+		rate:    config.AccessLogSampleRate,
+		block:   config.AccessLogBlock,
+		entries: make(chan *accessLogEntry, accessLogMaxPending),
+		done:    make(chan struct{}),
+	}
+	for _, opstr := range config.AccessLogInclude {
+		l.include[strings.ToUpper(opstr)] = true
+	}
+	for _, opstr := range config.AccessLogExclude {
+		l.exclude[strings.ToUpper(opstr)] = true
+	}
+	l.exit.C = make(chan struct{})
+	go l.loopWriter()
+	return l, nil
+}
+
+func (l *accessLog) match(opstr string) bool {
+	if l.exclude[opstr] {
+		return false
+	}
+	if len(l.include) != 0 && !l.include[opstr] {
+		return false
+	}
+	return l.rate >= 1 || rand.Float64() < l.rate
+}
+
+/*
+	record a replied request of the client, resp is nil for subscription commands
+ */
+func (l *accessLog) record(client *Client, r *Request, resp *redis.Resp) {
+	if !l.match(r.OpStr) {
+		return
+	}
+	client.mu.Lock()
+	user, database := client.user, client.database
+	client.mu.Unlock()
+
+	e := &accessLogEntry{
+		Time:     time.Now().Format(time.RFC3339Nano),
+		Client:   client.RemoteAddr(),
+		User:     user,
+		Database: database,
+		Cmd:      r.OpStr,
+		Latency:  time.Since(r.Start).Microseconds(),
+	}
+	for _, key := range getKeys(r.Multi, r.OpStr) {
+		if len(e.Keys) == accessLogMaxKeys {
+			break
+		}
+		e.Keys = append(e.Keys, truncateArg(key))
+	}
+	if resp != nil {
+		e.Reply = strings.Trim(resp.Type.String(), "<>")
+		if resp.IsError() {
+			e.Error = truncateArg(resp.Value)
+		}
+	}
+	l.push(e)
+}
+
+func (l *accessLog) push(e *accessLogEntry) {
+	if l.block {
+		select {
+		case l.entries <- e:
+		case <-l.exit.C:
+			l.dropped.Incr()
+		}
+		return
+	}
+	select {
+	case l.entries <- e:
+	default:
+		l.dropped.Incr()
+	}
+}
+
+func (l *accessLog) loopWriter() {
+	defer close(l.done)
+	w := bufio.NewWriter(l.out)
+	write := func(e *accessLogEntry) {
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.WarnErrorf(err, "access log encode failed")
+			return
+		}
+		w.Write(b)
+		w.WriteByte('\n')
+	}
+	var lastDropped int64
+	for {
+		select {
+		case e := <-l.entries:
+			write(e)
+			if len(l.entries) != 0 {
+				continue
+			}
+		case <-l.exit.C:
+			for len(l.entries) != 0 {
+				write(<-l.entries)
+			}
+			if err := w.Flush(); err != nil {
+				log.WarnErrorf(err, "access log write failed")
+			}
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.WarnErrorf(err, "access log write failed")
+		}
+		if n := l.dropped.Get(); n != lastDropped {
+			log.Warnf("access log dropped %d entries", n-lastDropped)
+			lastDropped = n
+		}
+	}
+}
+
+/*
+	write pending entries and close the file
+ */
+func (l *accessLog) Close() error {
+	close(l.exit.C)
+	<-l.done
+	return l.out.Close()
+}
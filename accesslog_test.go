@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+func TestAccessLogMatch(t *testing.T) {
+	l := &accessLog{
+		include: map[string]bool{"GET": true, "SET": true},
+		exclude: map[string]bool{"SET": true},
+		rate:    1,
+	}
+	assert.Must(l.match("GET") && !l.match("SET") && !l.match("DEL"))
+
+	l.include, l.rate = map[string]bool{}, 0.5
+	var n int
+	for i := 0; i < 10000; i++ {
+		if l.match("DEL") {
+			n++
+		}
+	}
+	assert.Must(n > 4000 && n < 6000)
+}
+
+func TestAccessLogPush(t *testing.T) {
+	l := &accessLog{entries: make(chan *accessLogEntry, 1)}
+	l.exit.C = make(chan struct{})
+	l.push(&accessLogEntry{})
+	l.push(&accessLogEntry{})
+	assert.Must(len(l.entries) == 1 && l.dropped.Get() == 1)
+
+	// access_log_block: wait for the writer
+	l.block = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.push(&accessLogEntry{Cmd: "SET"})
+	}()
+	time.Sleep(time.Millisecond * 50)
+	select {
+	case <-done:
+		assert.Must(false)
+	default:
+	}
+	<-l.entries
+	<-done
+	assert.Must(len(l.entries) == 1 && l.dropped.Get() == 1)
+
+	// closed: never blocks
+	close(l.exit.C)
+	l.push(&accessLogEntry{})
+	assert.Must(len(l.entries) == 1 && l.dropped.Get() == 2)
+}
+
+func TestAccessLog(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	dir := t.TempDir()
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.SessionUsers = []*UserConfig{{Name: "app", Password: HashPassword("app", "secret"), Rules: "+@all"}}
+	config.AccessLogFile = filepath.Join(dir, "access.log")
+	config.AccessLogRolling = "hourly"
+	config.AccessLogExclude = []string{"ping"}
+	server, errc := startTestServer(config)
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	r := bufio.NewReader(c)
+	_, err = c.Write([]byte("AUTH app secret\r\nSET k v\r\nPING\r\nMSET a 1 b 2\r\nGET\r\n"))
+	assert.MustNoError(err)
+	for _, expect := range []string{"+OK", "+OK", "+PONG", "+OK", "-ERR wrong number"} {
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		assert.Must(strings.HasPrefix(line, expect))
+	}
+	c.Close()
+	assert.MustNoError(server.Close())
+	assert.MustNoError(<-errc)
+
+	files, err := filepath.Glob(config.AccessLogFile + ".*")
+	assert.MustNoError(err)
+	assert.Must(len(files) != 0)
+	// 跨小时时日志分为两个文件
+	var b []byte
+	for _, file := range files {
+		assert.Must(len(filepath.Ext(file)) == len(".2006-01-02-15"))
+		data, err := os.ReadFile(file)
+		assert.MustNoError(err)
+		b = append(b, data...)
+	}
+
+	var entries []*accessLogEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var e accessLogEntry
+		assert.MustNoError(json.Unmarshal([]byte(line), &e))
+		entries = append(entries, &e)
+	}
+	assert.Must(len(entries) == 4)
+	for i, cmd := range []string{"AUTH", "SET", "MSET", "GET"} {
+		e := entries[i]
+		assert.Must(e.Cmd == cmd && e.User == "app" && e.Client == c.LocalAddr().String() && e.Time != "")
+	}
+	assert.Must(len(entries[0].Keys) == 0 && entries[0].Reply == "string")
+	assert.Must(len(entries[1].Keys) == 1 && entries[1].Keys[0] == "k")
+	assert.Must(strings.Join(entries[2].Keys, ",") == "a,b")
+	assert.Must(entries[3].Reply == "error" && strings.HasPrefix(entries[3].Error, "ERR wrong number"))
+	assert.Must(!strings.Contains(string(b), "secret"))
+}
@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"strings"
+	"sync"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+)
+
+/*
+	acl: permissions of proxy users, rules are applied in order and the last match wins
+	  +<command> / -<command>     allow / deny a command
+	  +@<category> / -@<category> allow / deny all, read, write, admin or dangerous commands
+	  allcommands / nocommands    alias of +@all / -@all
+	  ~<pattern>                  allow keys matching glob pattern, all keys if no pattern is given
+	  allkeys / resetkeys         alias of ~* / forget previous patterns
+	users without rules and sessions without session_users follow session_default_rules
+ */
+
+const DefaultACLRules = "+@all -@admin -@dangerous +info"
+
+var aclCategories = map[string]commandFlag{
+	"all":       0,
+	"read":      flagReadOnly,
+	"write":     flagWrite,
+	"admin":     flagAdmin,
+	"dangerous": flagDangerous | flagAdmin,
+}
+
+type aclRule struct {
+	allow    bool
+	command  string
+	category commandFlag
+}
+
+func (rule *aclRule) match(opstr string) bool {
+	if rule.command != "" {
+		return rule.command == opstr
+	}
+	if rule.category == 0 {
+		return true
+	}
+	c := commandTable[opstr]
+	return c != nil && c.hasFlag(rule.category)
+}
+
+type aclUser struct {
+	name     string
+	password string
+
+	rules []*aclRule
+	keys  []string // glob patterns of allowed keys
+}
+
+func parseACLRules(rules string) (*aclUser, error) {
+	var u = &aclUser{}
+	var keys = false
+	for _, token := range strings.Fields(rules) {
+		switch lower := strings.ToLower(token); {
+		case lower == "allcommands":
+			u.rules = append(u.rules, &aclRule{allow: true})
+		case lower == "nocommands":
+			u.rules = append(u.rules, &aclRule{allow: false})
+		case lower == "allkeys":
+			keys, u.keys = true, append(u.keys, "*")
+		case lower == "resetkeys":
+			keys, u.keys = true, nil
+		case token[0] == '~':
+			keys, u.keys = true, append(u.keys, token[1:])
+		case len(token) > 1 && (token[0] == '+' || token[0] == '-'):
+			rule := &aclRule{allow: token[0] == '+'}
+			if token[1] == '@' {
+				category, ok := aclCategories[lower[2:]]
+				if !ok {
+					return nil, errors.Errorf("unknown acl category %q", token)
+				}
+				rule.category = category
+			} else {
+				rule.command = strings.ToUpper(token[1:])
+				if commandTable[rule.command] == nil {
+					return nil, errors.Errorf("unknown acl command %q", token)
+				}
+			}
+			u.rules = append(u.rules, rule)
+		default:
+			return nil, errors.Errorf("invalid acl rule %q", token)
+		}
+	}
+	if !keys {
+		u.keys = []string{"*"}
+	}
+	return u, nil
+}
+
+func (u *aclUser) checkCommand(opstr string) bool {
+	var allowed = false
+	for _, rule := range u.rules {
+		if rule.match(opstr) {
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+/*
+	true if any key is allowed, keys of commands out of the command table can't be checked otherwise
+ */
+func (u *aclUser) allKeys() bool {
+	for _, pattern := range u.keys {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *aclUser) checkKeys(keys [][]byte) bool {
+	for _, key := range keys {
+		var matched = false
+		for _, pattern := range u.keys {
+			if globMatch(pattern, string(key)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	users and rules, reloadable at runtime
+ */
+type ACL struct {
+	mu        sync.RWMutex
+	users     map[string]*aclUser
+	anonymous *aclUser // sessions without session_users
+}
+
+func NewACL(users []*UserConfig, defaultRules string) (*ACL, error) {
+	acl := &ACL{}
+	if err := acl.Reload(users, defaultRules); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+/*
+	replace all users and rules, nothing changes on error
+ */
+func (acl *ACL) Reload(users []*UserConfig, defaultRules string) error {
+	anonymous, err := parseACLRules(defaultRules)
+	if err != nil {
+		return errors.Errorf("invalid session_default_rules, %s", err)
+	}
+	var m = make(map[string]*aclUser, len(users))
+	for _, u := range users {
+		var rules = u.Rules
+		if rules == "" {
+			rules = defaultRules
+		}
+		p, err := parseACLRules(rules)
+		if err != nil {
+			return errors.Errorf("invalid rules of user %q, %s", u.Name, err)
+		}
+		p.name, p.password = u.Name, u.Password
+		m[u.Name] = p
+	}
+
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	acl.users, acl.anonymous = m, anonymous
+	return nil
+}
+
+func (acl *ACL) HasUsers() bool {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	return len(acl.users) != 0
+}
+
+/*
+	check the password of user, passwords are hashed by HashPassword
+ */
+func (acl *ACL) Authenticate(user, password string) bool {
+	acl.mu.RLock()
+	u := acl.users[user]
+	acl.mu.RUnlock()
+	if u == nil {
+		return false
+	}
+	hash := HashPassword(user, password)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(u.password)) == 1
+}
+
+/*
+	nil if user is allowed to run the request, or a -NOPERM reply
+	user is empty for sessions without session_users
+ */
+func (acl *ACL) Check(user string, r *Request) *redis.Resp {
+	acl.mu.RLock()
+	u := acl.anonymous
+	if user != "" {
+		u = acl.users[user]
+	}
+	acl.mu.RUnlock()
+
+	if u == nil || !u.checkCommand(r.OpStr) {
+		return redis.NewErrorf("NOPERM this user has no permissions to run the '%s' command or its subcommand", strings.ToLower(r.OpStr))
+	}
+	if commandTable[r.OpStr] == nil && !u.allKeys() {
+		return redis.NewErrorf("NOPERM this user has no permissions to run the '%s' command or its subcommand", strings.ToLower(r.OpStr))
+	}
+	if !u.checkKeys(getKeys(r.Multi, r.OpStr)) {
+		return redis.NewErrorf("NOPERM this user has no permissions to access one of the keys used as arguments")
+	}
+	return nil
+}
+
+/*
+	redis style glob: * ? [abc] [^a-z] and \ escape
+ */
+func globMatch(pattern, s string) bool {
+	for len(pattern) != 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if len(s) == 0 || end < 0 {
+				return false
+			}
+			class, not := pattern[1:1+end], false
+			if len(class) != 0 && class[0] == '^' {
+				class, not = class[1:], true
+			}
+			var matched = false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == not {
+				return false
+			}
+			pattern = pattern[1+end:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
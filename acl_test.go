@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+func newTestRequest(args ...string) *Request {
+	var multi []*redis.Resp
+	for _, arg := range args {
+		multi = append(multi, redis.NewBulkBytes([]byte(arg)))
+	}
+	opstr, err := (&utils{}).opStr(multi)
+	assert.MustNoError(err)
+	return NewRequest(multi, opstr)
+}
+
+func TestGlobMatch(t *testing.T) {
+	var tests = []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"app:*", "app:1", true},
+		{"app:*", "ap", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"*:*:x", "a:b:x", true},
+	}
+	for _, test := range tests {
+		assert.Must(globMatch(test.pattern, test.s) == test.match)
+	}
+}
+
+func TestACLRules(t *testing.T) {
+	u, err := parseACLRules(DefaultACLRules)
+	assert.MustNoError(err)
+	assert.Must(u.checkCommand("GET") && u.checkCommand("SET"))
+	assert.Must(!u.checkCommand("FLUSHALL") && !u.checkCommand("CONFIG") && !u.checkCommand("KEYS"))
+	assert.Must(u.checkCommand("INFO") && !u.checkCommand("SLOWLOG"))
+
+	u, err = parseACLRules("+@read -hgetall +set ~app:* ~{tag}*")
+	assert.MustNoError(err)
+	assert.Must(u.checkCommand("GET") && u.checkCommand("SET"))
+	assert.Must(!u.checkCommand("HGETALL") && !u.checkCommand("DEL"))
+	assert.Must(u.checkKeys([][]byte{[]byte("app:1"), []byte("{tag}x")}))
+	assert.Must(!u.checkKeys([][]byte{[]byte("app:1"), []byte("other")}))
+
+	u, err = parseACLRules("allcommands -@write")
+	assert.MustNoError(err)
+	assert.Must(u.checkCommand("PING") && !u.checkCommand("SET"))
+
+	for _, rules := range []string{"+@foo", "+nosuchcommand", "app:*"} {
+		_, err := parseACLRules(rules)
+		assert.Must(err != nil)
+	}
+}
+
+func TestACLCheck(t *testing.T) {
+	acl, err := NewACL([]*UserConfig{
+		{Name: "app", Password: HashPassword("app", "secret"), Rules: "+@all -@write ~app:*"},
+	}, DefaultACLRules)
+	assert.MustNoError(err)
+
+	assert.Must(acl.Check("app", newTestRequest("GET", "app:1")) == nil)
+	assert.Must(acl.Check("app", newTestRequest("MGET", "app:1", "app:2")) == nil)
+	assert.Must(acl.Check("app", newTestRequest("GET", "other")).IsError())
+	assert.Must(acl.Check("app", newTestRequest("SET", "app:1", "v")).IsError())
+	assert.Must(acl.Check("nobody", newTestRequest("GET", "app:1")).IsError())
+	assert.Must(acl.Check("", newTestRequest("SET", "k", "v")) == nil)
+
+	// commands out of the table are denied to users with key patterns
+	assert.Must(acl.Check("app", newTestRequest("NOSUCHCOMMAND", "other")).IsError())
+	assert.Must(acl.Check("", newTestRequest("NOSUCHCOMMAND", "other")) == nil)
+	assert.Must(acl.Check("app", newTestRequest("GETDEL", "other")).IsError())
+	assert.Must(acl.Check("app", newTestRequest("XRANGE", "other", "-", "+")).IsError())
+	assert.Must(acl.Check("app", newTestRequest("XRANGE", "app:s", "-", "+")) == nil)
+	assert.Must(acl.Check("app", newTestRequest("XREAD", "COUNT", "1", "STREAMS", "app:s", "other", "0", "0")).IsError())
+	assert.Must(acl.Check("app", newTestRequest("XREAD", "STREAMS", "app:s", "0")) == nil)
+
+	// reload
+	assert.MustNoError(acl.Reload([]*UserConfig{
+		{Name: "app", Password: HashPassword("app", "secret"), Rules: "+@all"},
+	}, "nocommands"))
+	assert.Must(acl.Check("app", newTestRequest("SET", "other", "v")) == nil)
+	assert.Must(acl.Check("", newTestRequest("PING")).IsError())
+	assert.Must(acl.Reload(nil, "+nosuchcommand") != nil)
+	assert.Must(acl.Check("app", newTestRequest("SET", "other", "v")) == nil)
+}
+
+func TestClientACL(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.SessionUsers = []*UserConfig{
+		{Name: "app", Password: HashPassword("app", "secret"), Rules: "+@read ~app:*"},
+	}
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	var tests = []struct {
+		request, reply string
+	}{
+		{"*3\r\n$4\r\nAUTH\r\n$3\r\napp\r\n$6\r\nsecret\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\n0\r\n", "-NOPERM this user has no permissions to access one of the keys used as arguments\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$5\r\napp:1\r\n$1\r\nv\r\n", "-NOPERM this user has no permissions to run the 'set' command or its subcommand\r\n"},
+		{"*2\r\n$6\r\nEXISTS\r\n$5\r\napp:1\r\n", "+OK\r\n"},
+	}
+	for _, test := range tests {
+		_, err := c.Write([]byte(test.request))
+		assert.MustNoError(err)
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		assert.Must(line == test.reply)
+	}
+
+	// reload: app can write now
+	assert.MustNoError(server.ReloadACL([]*UserConfig{
+		{Name: "app", Password: HashPassword("app", "secret"), Rules: "+@all ~app:*"},
+	}, DefaultACLRules))
+	_, err = c.Write([]byte("*3\r\n$3\r\nSET\r\n$5\r\napp:1\r\n$1\r\nv\r\n"))
+	assert.MustNoError(err)
+	line, err := r.ReadString('\n')
+	assert.MustNoError(err)
+	assert.Must(line == "+OK\r\n")
+}
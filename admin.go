@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sysutils "SSAWPROXY/redisProxy/utils"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/rpc"
+)
+
+/*
+	http admin listener on admin_addr, disabled if admin_addr is empty
+	1.GET /metrics: prometheus metrics, see metrics.go
+	2.cpu usage is sampled in background every cpuSamplePeriod
+	3.json api, ?xauth=AdminXAuth(admin_auth) is required, the api is disabled if admin_auth is empty,
+	  errors are replied by rpc.ApiResponseError and can be read by rpc.ApiGetJson & rpc.ApiPutJson
+	  GET /api/sessions               : alive sessions
+	  PUT /api/sessions/kill/{id}     : close a session
+	  GET /api/backends               : backend addresses and their pool state
+	  GET /api/blocklist              : blocked commands, except the fixed protocol commands (MONITOR, CLIENT, ...)
+	  PUT /api/blocklist              : replace blocked commands, body is a json array
+	  PUT /api/refresh                : reload topology, see Router.Reload
+	  PUT /api/loglevel/{level}       : debug, info, warn or error
+	  GET /api/slowlog                : slow log, newest first, ?count=N limits entries
+	  PUT /api/slowlog/reset          : clear slow log
+ */
+
+const cpuSamplePeriod = time.Second * 5
+
+type adminServer struct {
+	mu       sync.Mutex
+	server   *Server
+	listener net.Listener
+	cpu      float64 // latest cpu usage
+
+	exit struct {
+		C chan struct{}
+	}
+	closed bool
+}
+
+/*
+	listen admin_addr and serve http in background
+ */
+func newAdminServer(server *Server) (*adminServer, error) {
+	l, err := net.Listen("tcp", server.config.AdminAddr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	admin := &adminServer{server: server, listener: l}
+	admin.exit.C = make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", admin.handleMetrics)
+	mux.HandleFunc("/api/", admin.handleApi)
+
+	go func() {
+		if err := http.Serve(l, mux); err != nil && !admin.isClosed() {
+			log.WarnErrorf(err, "admin listener on %s exited", l.Addr())
+		}
+	}()
+	go admin.loopUsage()
+	return admin, nil
+}
+
+func (admin *adminServer) Addr() string {
+	return admin.listener.Addr().String()
+}
+
+/*
+	sample cpu usage until admin is closed, utils.CPUUsage blocks for a period
+ */
+func (admin *adminServer) loopUsage() {
+	for !admin.isClosed() {
+		cpu, _, err := sysutils.CPUUsage(cpuSamplePeriod)
+		if err != nil {
+			log.WarnErrorf(err, "admin sample cpu usage failed")
+			select {
+			case <-admin.exit.C:
+			case <-time.After(cpuSamplePeriod):
+			}
+			continue
+		}
+		admin.mu.Lock()
+		admin.cpu = cpu
+		admin.mu.Unlock()
+	}
+}
+
+func (admin *adminServer) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin.mu.Lock()
+	cpu := admin.cpu
+	admin.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := admin.server.writeMetrics(w, cpu); err != nil {
+		log.WarnErrorf(err, "admin write metrics to %s failed", req.RemoteAddr)
+	}
+}
+
+type adminRoute struct {
+	method string
+	path   string // path ending with "/" matches its sub paths, the rest is passed as arg
+	handle func(admin *adminServer, req *http.Request, arg string) (interface{}, error)
+}
+
+var adminRoutes = []*adminRoute{
+	{http.MethodGet, "/api/sessions", (*adminServer).listSessions},
+	{http.MethodPut, "/api/sessions/kill/", (*adminServer).killSession},
+	{http.MethodGet, "/api/backends", (*adminServer).listBackends},
+	{http.MethodGet, "/api/blocklist", (*adminServer).getBlocklist},
+	{http.MethodPut, "/api/blocklist", (*adminServer).setBlocklist},
+	{http.MethodPut, "/api/refresh", (*adminServer).refresh},
+	{http.MethodPut, "/api/loglevel/", (*adminServer).setLogLevel},
+	{http.MethodGet, "/api/slowlog", (*adminServer).getSlowlog},
+	{http.MethodPut, "/api/slowlog/reset", (*adminServer).resetSlowlog},
+}
+
+/*
+	token of the admin api
+ */
+func AdminXAuth(auth string) string {
+	return rpc.NewXAuth(auth)
+}
+
+func (admin *adminServer) handleApi(w http.ResponseWriter, req *http.Request) {
+	auth := admin.server.config.AdminAuth
+	if auth == "" {
+		// 未设置admin_auth时不开放api, 只导出metrics
+		http.Error(w, "admin api is disabled, admin_auth is not set", http.StatusForbidden)
+		return
+	}
+	xauth := req.URL.Query().Get("xauth")
+	if subtle.ConstantTimeCompare([]byte(xauth), []byte(AdminXAuth(auth))) != 1 {
+		http.Error(w, "invalid xauth", http.StatusForbidden)
+		return
+	}
+	var found bool
+	for _, route := range adminRoutes {
+		var arg string
+		switch {
+		case strings.HasSuffix(route.path, "/") && strings.HasPrefix(req.URL.Path, route.path):
+			arg = strings.TrimPrefix(req.URL.Path, route.path)
+		case req.URL.Path != route.path:
+			continue
+		}
+		if found = true; req.Method != route.method {
+			continue
+		}
+		v, err := route.handle(admin, req, arg)
+		var code int
+		var body string
+		if err != nil {
+			log.WarnErrorf(err, "admin api [%s] %s failed", req.Method, req.URL.Path)
+			code, body = rpc.ApiResponseError(err)
+		} else {
+			code, body = rpc.ApiResponseJson(v)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		io.WriteString(w, body)
+		return
+	}
+	if found {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	} else {
+		http.NotFound(w, req)
+	}
+}
+
+func (admin *adminServer) listSessions(req *http.Request, arg string) (interface{}, error) {
+	clients := admin.server.clients.List()
+	sessions := make([]*sessionInfo, 0, len(clients))
+	for _, client := range clients {
+		sessions = append(sessions, client.sessionInfo())
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Id < sessions[j].Id
+	})
+	return sessions, nil
+}
+
+func (admin *adminServer) killSession(req *http.Request, arg string) (interface{}, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("invalid session id = %q", arg)
+	}
+	for _, client := range admin.server.clients.List() {
+		if client.id == id {
+			log.Warnf("admin kill session %d [%s]", id, client.RemoteAddr())
+			client.Close()
+			return "OK", nil
+		}
+	}
+	return nil, errors.Errorf("session %d not found", id)
+}
+
+/*
+	backend address and its pool state, role is master, replica or empty
+ */
+type backendInfo struct {
+	Addr  string `json:"addr"`
+	Role  string `json:"role"`
+	Conns int    `json:"conns"`
+}
+
+func (admin *adminServer) listBackends(req *http.Request, arg string) (interface{}, error) {
+	router := admin.server.router
+	var roles = make(map[string]string)
+	for _, addr := range router.masters() {
+		roles[addr] = "master"
+	}
+	router.mu.RLock()
+	for _, node := range router.replicas {
+		roles[node.addr] = "replica"
+	}
+	router.mu.RUnlock()
+
+	pools := admin.server.manager.Stats()
+	for addr := range roles {
+		if _, ok := pools[addr]; !ok {
+			pools[addr] = 0
+		}
+	}
+	backends := make([]*backendInfo, 0, len(pools))
+	for _, addr := range sortedKeys(pools) {
+		backends = append(backends, &backendInfo{Addr: addr, Role: roles[addr], Conns: pools[addr]})
+	}
+	return backends, nil
+}
+
+func (admin *adminServer) getBlocklist(req *http.Request, arg string) (interface{}, error) {
+	return admin.server.filter.List(), nil
+}
+
+func (admin *adminServer) setBlocklist(req *http.Request, arg string) (interface{}, error) {
+	var commands []string
+	if err := json.NewDecoder(req.Body).Decode(&commands); err != nil {
+		return nil, errors.Errorf("invalid blocklist, %s", err)
+	}
+	admin.server.filter.Set(commands)
+	list := admin.server.filter.List()
+	log.Warnf("admin set blocklist %v", list)
+	return list, nil
+}
+
+func (admin *adminServer) refresh(req *http.Request, arg string) (interface{}, error) {
+	if err := admin.server.router.Reload(); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+func (admin *adminServer) setLogLevel(req *http.Request, arg string) (interface{}, error) {
+	if !log.SetLevelString(arg) {
+		return nil, errors.Errorf("invalid log level = %q", arg)
+	}
+	log.Warnf("admin set log level %s", arg)
+	return "OK", nil
+}
+
+func (admin *adminServer) getSlowlog(req *http.Request, arg string) (interface{}, error) {
+	count := -1
+	if s := req.URL.Query().Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < -1 {
+			return nil, errors.Errorf("invalid count = %q", s)
+		}
+		count = n
+	}
+	return admin.server.slowlog.Get(count), nil
+}
+
+func (admin *adminServer) resetSlowlog(req *http.Request, arg string) (interface{}, error) {
+	admin.server.slowlog.Reset()
+	return "OK", nil
+}
+
+func (admin *adminServer) isClosed() bool {
+	admin.mu.Lock()
+	defer admin.mu.Unlock()
+	return admin.closed
+}
+
+func (admin *adminServer) Close() error {
+	admin.mu.Lock()
+	defer admin.mu.Unlock()
+	if admin.closed {
+		return nil
+	}
+	admin.closed = true
+	close(admin.exit.C)
+	return admin.listener.Close()
+}
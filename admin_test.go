@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+	"SSAWPROXY/redisProxy/utils/rpc"
+)
+
+func TestAdminApi(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.AdminAddr = "127.0.0.1:0"
+	config.AdminAuth = "secret"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.SessionDefaultRules = "+@all"
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	api := func(path string) string {
+		return rpc.EncodeURL(server.AdminAddr(), "%s", path) + "?xauth=" + AdminXAuth("secret")
+	}
+
+	c1 := dialTestConn(server.Addr())
+	defer c1.Close()
+	expectString(c1.do("SELECT", "2"), "OK")
+	c2 := dialTestConn(server.Addr())
+	defer c2.Close()
+	expectString(c2.do("SET", "k", "v"), "OK")
+
+	// token is required
+	var sessions []*sessionInfo
+	err := rpc.ApiGetJson(rpc.EncodeURL(server.AdminAddr(), "/api/sessions"), &sessions)
+	assert.Must(err != nil && strings.Contains(err.Error(), "403"))
+
+	assert.MustNoError(rpc.ApiGetJson(api("/api/sessions"), &sessions))
+	assert.Must(len(sessions) == 2 && sessions[0].Id < sessions[1].Id)
+	assert.Must(sessions[0].Addr == c1.LocalAddr().String() && sessions[0].Database == 2 && sessions[0].Ops == 1)
+	assert.Must(sessions[1].Addr == c2.LocalAddr().String() && sessions[1].Database == 0)
+
+	var backends []*backendInfo
+	assert.MustNoError(rpc.ApiGetJson(api("/api/backends"), &backends))
+	assert.Must(len(backends) == 1 && backends[0].Addr == backend.Addr().String() && backends[0].Role == "master")
+
+	// blocklist is applied to alive sessions
+	var blocklist []string
+	assert.MustNoError(rpc.ApiGetJson(api("/api/blocklist"), &blocklist))
+	assert.Must(strings.Contains(strings.Join(blocklist, ","), "SLOTSINFO"))
+	assert.MustNoError(rpc.ApiPutJson(api("/api/blocklist"), []string{"set", "move"}, &blocklist))
+	assert.Must(len(blocklist) == 2 && blocklist[0] == "MOVE" && blocklist[1] == "SET")
+	expectString(c2.do("SET", "k", "v"), "ERR the command 'set' is not supported")
+	assert.MustNoError(rpc.ApiPutJson(api("/api/blocklist"), []string{}, &blocklist))
+	assert.Must(len(blocklist) == 0)
+	expectString(c2.do("SET", "k", "v"), "OK")
+	// protocol commands can't be unblocked
+	expectString(c2.do("MONITOR"), "ERR the command 'monitor' is not supported")
+
+	var reply string
+	assert.MustNoError(rpc.ApiPutJson(api("/api/refresh"), nil, &reply))
+	assert.Must(reply == "OK")
+	assert.Must(rpc.ApiPutJson(api("/api/loglevel/verbose"), nil, &reply) != nil)
+	assert.MustNoError(rpc.ApiPutJson(api("/api/loglevel/debug"), nil, &reply))
+
+	// kill closes the session only
+	assert.Must(rpc.ApiPutJson(api("/api/sessions/kill/x"), nil, &reply) != nil)
+	assert.MustNoError(rpc.ApiPutJson(api("/api/sessions/kill/"+strconv.FormatInt(sessions[0].Id, 10)), nil, &reply))
+	_, err = c1.d.Decode()
+	assert.Must(err != nil)
+	expectString(c2.do("SET", "k", "v"), "OK")
+	assert.Must(rpc.ApiPutJson(api("/api/sessions/kill/"+strconv.FormatInt(sessions[0].Id, 10)), nil, &reply) != nil)
+}
+
+func TestAdminApiWithoutAuth(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.AdminAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	// api is disabled without admin_auth, metrics are still served
+	var sessions []*sessionInfo
+	err := rpc.ApiGetJson(rpc.EncodeURL(server.AdminAddr(), "/api/sessions"), &sessions)
+	assert.Must(err != nil && strings.Contains(err.Error(), "403"))
+	var reply string
+	err = rpc.ApiPutJson(rpc.EncodeURL(server.AdminAddr(), "/api/slowlog/reset"), nil, &reply)
+	assert.Must(err != nil && strings.Contains(err.Error(), "403"))
+
+	rsp, err := http.Get("http://" + server.AdminAddr() + "/metrics")
+	assert.MustNoError(err)
+	rsp.Body.Close()
+	assert.Must(rsp.StatusCode == http.StatusOK)
+}
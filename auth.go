@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/rpc"
+)
+
+/*
+	client authentication by proxy users, the backend password is never exposed
+	1.no session_users: AUTH is rejected, every client is accepted
+	2.session_users: commands before a successful AUTH get -NOAUTH
+	  AUTH password      -> user "default"
+	  AUTH user password -> Redis 6 style
+	3.passwords are stored as HashPassword(user, password)
+	4.permissions of the user are checked by acl
+	5.HELLO is replied by proxy, only RESP2 is supported, HELLO ... AUTH is checked as AUTH
+ */
+
+const DefaultUser = "default"
+
+// 命令表对应的redis版本, HELLO回复
+const helloVersion = "6.2.0"
+
+/*
+	hashed password stored in session_users
+ */
+func HashPassword(user, password string) string {
+	return rpc.NewXAuth(user, password)
+}
+
+type UserConfig struct {
+	Name     string `toml:"name" json:"name"`
+	Password string `toml:"password" json:"-"`
+	Rules    string `toml:"rules" json:"rules"`
+}
+
+/*
+	AUTH [user] password
+ */
+func (client *Client) handleAuth(r *Request) *redis.Resp {
+	var user, password string
+	switch len(r.Multi) {
+	case 2:
+		user, password = DefaultUser, string(r.Multi[1].Value)
+	case 3:
+		user, password = string(r.Multi[1].Value), string(r.Multi[2].Value)
+	default:
+		return redis.NewErrorf("ERR wrong number of arguments for 'auth' command")
+	}
+	if resp := client.authenticate(user, password); resp != nil {
+		return resp
+	}
+	return redis.NewString([]byte("OK"))
+}
+
+/*
+	nil if user & password are valid and the session is authorized as user, or an error reply
+ */
+func (client *Client) authenticate(user, password string) *redis.Resp {
+	if !client.server.acl.HasUsers() {
+		return redis.NewErrorf("ERR Client sent AUTH, but no password is set")
+	}
+	if !client.server.acl.Authenticate(user, password) {
+		return redis.NewErrorf("WRONGPASS invalid username-password pair")
+	}
+	client.mu.Lock()
+	client.user = user
+	client.mu.Unlock()
+	client.authorized = true
+	return nil
+}
+
+/*
+	HELLO [protover [AUTH username password] [SETNAME clientname]]
+	SETNAME is accepted but not kept, client names are not supported by proxy
+ */
+func (client *Client) handleHello(r *Request) *redis.Resp {
+	var auth []*redis.Resp
+	if len(r.Multi) > 1 {
+		protover, err := redis.Btoi64(r.Multi[1].Value)
+		if err != nil {
+			return redis.NewErrorf("ERR Protocol version is not an integer or out of range")
+		}
+		if protover != 2 {
+			return redis.NewErrorf("NOPROTO sorry, this protocol version is not supported")
+		}
+		for i := 2; i < len(r.Multi); i++ {
+			switch opt := strings.ToUpper(string(r.Multi[i].Value)); {
+			case opt == "AUTH" && i+2 < len(r.Multi):
+				auth, i = r.Multi[i+1:i+3], i+2
+			case opt == "SETNAME" && i+1 < len(r.Multi):
+				i++
+			default:
+				return redis.NewErrorf("ERR Syntax error in HELLO option '%s'", r.Multi[i].Value)
+			}
+		}
+	}
+	if auth != nil {
+		if resp := client.authenticate(string(auth[0].Value), string(auth[1].Value)); resp != nil {
+			return resp
+		}
+	}
+	if !client.authorized {
+		return redis.NewErrorf("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	bulk := func(s string) *redis.Resp {
+		return redis.NewBulkBytes([]byte(s))
+	}
+	return redis.NewArray([]*redis.Resp{
+		bulk("server"), bulk("redis"),
+		bulk("version"), bulk(helloVersion),
+		bulk("proto"), redis.NewInt([]byte("2")),
+		bulk("id"), redis.NewInt([]byte(strconv.FormatInt(client.id, 10))),
+		bulk("mode"), bulk("standalone"),
+		bulk("role"), bulk("master"),
+		bulk("modules"), redis.NewArray(nil),
+	})
+}
+
+func isValidPasswordHash(hash string) bool {
+	if len(hash) != 32 {
+		return false
+	}
+	return strings.Trim(hash, "0123456789abcdef") == ""
+}
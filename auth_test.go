@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+func TestClientAuth(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.SessionUsers = []*UserConfig{
+		{Name: DefaultUser, Password: HashPassword(DefaultUser, "foobar")},
+		{Name: "app", Password: HashPassword("app", "secret")},
+	}
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	var tests = []struct {
+		request, reply string
+	}{
+		{"*1\r\n$4\r\nPING\r\n", "-NOAUTH Authentication required.\r\n"},
+		{"*2\r\n$4\r\nAUTH\r\n$6\r\nfoobaz\r\n", "-WRONGPASS invalid username-password pair\r\n"},
+		{"*3\r\n$4\r\nAUTH\r\n$3\r\napp\r\n$6\r\nfoobar\r\n", "-WRONGPASS invalid username-password pair\r\n"},
+		{"*1\r\n$4\r\nPING\r\n", "-NOAUTH Authentication required.\r\n"},
+		{"*3\r\n$4\r\nAUTH\r\n$3\r\napp\r\n$6\r\nsecret\r\n", "+OK\r\n"},
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*2\r\n$4\r\nAUTH\r\n$6\r\nfoobar\r\n", "+OK\r\n"},
+	}
+	for _, test := range tests {
+		_, err := c.Write([]byte(test.request))
+		assert.MustNoError(err)
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		assert.Must(line == test.reply)
+	}
+}
+
+func TestClientHello(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.SessionUsers = []*UserConfig{
+		{Name: "app", Password: HashPassword("app", "secret")},
+	}
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c := dialTestConn(server.Addr())
+	defer c.Close()
+
+	assert.Must(strings.HasPrefix(string(c.do("HELLO").Value), "NOAUTH HELLO must be called"))
+	expectString(c.do("HELLO", "3"), "NOPROTO sorry, this protocol version is not supported")
+	expectString(c.do("HELLO", "2", "AUTH", "app", "wrong"), "WRONGPASS invalid username-password pair")
+	expectString(c.do("HELLO", "2", "AUTH", "app"), "ERR Syntax error in HELLO option 'AUTH'")
+	expectString(c.do("PING"), "NOAUTH Authentication required.")
+
+	resp := c.do("HELLO", "2", "AUTH", "app", "secret", "SETNAME", "worker")
+	assert.Must(resp.IsArray() && len(resp.Array) == 14)
+	assert.Must(string(resp.Array[4].Value) == "proto" && string(resp.Array[5].Value) == "2")
+	expectString(c.do("PING"), "PONG")
+	assert.Must(c.do("HELLO").IsArray())
+
+	expectString(c.do("RESET"), "ERR the command 'reset' is not supported")
+}
+
+func TestClientAuthNoUsers(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	_, err = c.Write([]byte("*2\r\n$4\r\nAUTH\r\n$6\r\nfoobar\r\n*1\r\n$4\r\nPING\r\n"))
+	assert.MustNoError(err)
+	line, err := r.ReadString('\n')
+	assert.MustNoError(err)
+	assert.Must(line == "-ERR Client sent AUTH, but no password is set\r\n")
+	line, err = r.ReadString('\n')
+	assert.MustNoError(err)
+	assert.Must(line == "+PONG\r\n")
+}
+
+func TestClientAuthReload(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c1 := dialTestConn(server.Addr())
+	defer c1.Close()
+	expectString(c1.do("PING"), "PONG")
+
+	// users added by reload, new sessions must AUTH
+	assert.MustNoError(server.ReloadACL([]*UserConfig{
+		{Name: "app", Password: HashPassword("app", "secret")},
+	}, DefaultACLRules))
+	c2 := dialTestConn(server.Addr())
+	defer c2.Close()
+	expectString(c2.do("PING"), "NOAUTH Authentication required.")
+	expectString(c2.do("AUTH", "app", "secret"), "OK")
+	expectString(c2.do("PING"), "PONG")
+
+	// users removed by reload, new sessions are authorized
+	assert.MustNoError(server.ReloadACL(nil, DefaultACLRules))
+	c3 := dialTestConn(server.Addr())
+	defer c3.Close()
+	expectString(c3.do("PING"), "PONG")
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+)
+
+/*
+	blocking commands of client sessions
+	1.commands flagged blocking (BLPOP, BZPOPMIN, BLMOVE, WAIT, ...) and XREAD/XREADGROUP with BLOCK
+	  are sent on a dedicated backend connection of the session, the shared pipelined connections are never blocked
+	2.the connection is dialed by the first blocking command and reused until the session exits
+	3.read timeout of the connection is the timeout of the command plus backend_recv_timeout,
+	  timeout 0 blocks forever
+	4.-MOVED/-ASK are followed on the dedicated connection
+	5.the connection is closed once the client disconnects, redis cancels the block
+ */
+
+var ErrClosedBlocker = errors.New("use of closed blocking connection")
+
+/*
+	true if the request may block the connection, see flagBlocking of the command table
+ */
+func isBlocking(r *Request) bool {
+	switch r.OpStr {
+	case "XREAD", "XREADGROUP":
+		return xreadBlock(r.Multi) > 0
+	}
+	c := commandTable[r.OpStr]
+	return c != nil && c.hasFlag(flagBlocking)
+}
+
+/*
+	index of the argument of BLOCK milliseconds in XREAD/XREADGROUP, 0 if not blocking
+ */
+func xreadBlock(multi []*redis.Resp) int {
+	for i := 1; i < len(multi)-1; i++ {
+		switch strings.ToUpper(string(multi[i].Value)) {
+		case "BLOCK":
+			return i + 1
+		case "STREAMS":
+			return 0
+		}
+	}
+	return 0
+}
+
+type blocker struct {
+	mu     sync.Mutex
+	client *Client
+	conn   *redisConn // not pooled and not running
+	input  chan *Request
+	closed bool
+}
+
+func newBlocker(client *Client) *blocker {
+	b := &blocker{
+		client: client,
+		input:  make(chan *Request, client.server.config.SessionMaxPipeline),
+	}
+	go b.loop()
+	return b
+}
+
+/*
+	timeout of a blocking command:
+	milliseconds after BLOCK of XREAD/XREADGROUP, the last argument in milliseconds of WAIT,
+	the last argument in seconds of the others
+ */
+func blockingTimeout(r *Request) (time.Duration, error) {
+	arg := r.Multi[len(r.Multi)-1].Value
+	switch r.OpStr {
+	case "XREAD", "XREADGROUP":
+		arg = r.Multi[xreadBlock(r.Multi)].Value
+		fallthrough
+	case "WAIT":
+		ms, err := strconv.ParseInt(string(arg), 10, 64)
+		if err != nil {
+			return 0, errors.New("timeout is not an integer or out of range")
+		}
+		if ms < 0 {
+			return 0, errors.New("timeout is negative")
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+/*
+	send a blocking command by loopReader, Batch is marked done once the reply arrives
+ */
+func (client *Client) dispatchBlocking(r *Request) {
+	if _, err := blockingTimeout(r); err != nil {
+		r.Resp = redis.NewErrorf("ERR %s", err)
+		return
+	}
+	if client.blocker == nil {
+		client.blocker = newBlocker(client)
+	}
+	// 回复已跟随重定向, 不再经过router.redirect
+	r.Pinned = true
+	r.Batch.Add(1)
+	client.blocker.input <- r
+}
+
+func (b *blocker) loop() {
+	for r := range b.input {
+		r.Resp, r.Err = b.do(r)
+		r.Batch.Done()
+	}
+}
+
+func (b *blocker) do(r *Request) (*redis.Resp, error) {
+	timeout, _ := blockingTimeout(r)
+	if timeout != 0 && b.client.server.config.BackendRecvTimeout.Get() != 0 {
+		timeout += b.client.server.config.BackendRecvTimeout.Get()
+	} else {
+		timeout = 0
+	}
+
+	router := b.client.server.router
+	addr, asking := router.lookup(r), false
+	for i := 0; ; i++ {
+		rConn, err := b.getConn(addr, r.Database)
+		if err != nil {
+			return nil, err
+		}
+		if asking {
+			if _, err := rConn.Do([]*redis.Resp{redis.NewBulkBytes([]byte("ASKING"))}); err != nil {
+				return nil, err
+			}
+		}
+		rConn.rc.ReaderTimeout = timeout
+		start := time.Now()
+		resp, err := rConn.Do(r.Multi)
+		r.Addr, r.Backend = addr, r.Backend+time.Since(start)
+		if err != nil {
+			return nil, err
+		}
+		ask, slot, target, ok := parseRedirect(resp)
+		if !ok || i == maxRedirects {
+			return resp, nil
+		}
+		router.server.stats.redirect(ask)
+		if !ask {
+			router.setSlot(slot, target)
+			router.triggerRefresh()
+		}
+		addr, asking = target, ask
+	}
+}
+
+/*
+	dedicated connection of addr with database selected, redialed if it is broken or the address changes
+ */
+func (b *blocker) getConn(addr string, database int) (*redisConn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosedBlocker
+	}
+	if b.conn != nil && (b.conn.addr != addr || b.conn.Err() != nil) {
+		b.conn.Close()
+		b.conn = nil
+	}
+	if b.conn != nil {
+		return b.conn, b.selectDatabase(database)
+	}
+	if addr == "" {
+		return nil, ErrNoMaster
+	}
+	rConn, err := b.client.server.manager.connect(addr)
+	if err != nil {
+		log.WarnErrorf(err, "client [%s] dial blocking connection to %s failed", b.client.RemoteAddr(), addr)
+		return nil, err
+	}
+	b.conn = rConn
+	return rConn, b.selectDatabase(database)
+}
+
+func (b *blocker) selectDatabase(database int) error {
+	if b.conn.database == database {
+		return nil
+	}
+	if err := b.conn.Select(database); err != nil {
+		return err
+	}
+	b.conn.database = database
+	return nil
+}
+
+/*
+	cancel the block in progress and fail the queued requests, called after loopReader exits
+ */
+func (b *blocker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.input)
+	if b.conn != nil {
+		b.conn.Close()
+	}
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	fake redis with blocking lists for tests:
+	LPUSH key value -> length or wakes up a blocked client,
+	BLPOP key timeout -> [key, value] or nil after timeout
+ */
+func newFakeLists() net.Listener {
+	var mu sync.Mutex
+	var lists = make(map[string][]string)
+	var waiters = make(map[string][]chan string)
+	blpop := func(key string, timeout time.Duration) string {
+		mu.Lock()
+		if list := lists[key]; len(list) != 0 {
+			lists[key] = list[1:]
+			mu.Unlock()
+			return "*2\r\n" + fakeBulk(key) + fakeBulk(list[0])
+		}
+		ch := make(chan string, 1)
+		waiters[key] = append(waiters[key], ch)
+		mu.Unlock()
+
+		var expire <-chan time.Time
+		if timeout != 0 {
+			expire = time.After(timeout)
+		}
+		select {
+		case value := <-ch:
+			return "*2\r\n" + fakeBulk(key) + fakeBulk(value)
+		case <-expire:
+			mu.Lock()
+			defer mu.Unlock()
+			for i, w := range waiters[key] {
+				if w == ch {
+					waiters[key] = append(waiters[key][:i], waiters[key][i+1:]...)
+					return "*-1\r\n"
+				}
+			}
+			return "*2\r\n" + fakeBulk(key) + fakeBulk(<-ch)
+		}
+	}
+	return newFakeServer(func() fakeHandler {
+		return func(c net.Conn, multi []string) string {
+			switch strings.ToUpper(multi[0]) {
+			case "BLPOP":
+				seconds, _ := strconv.ParseFloat(multi[2], 64)
+				return blpop(multi[1], time.Duration(seconds*float64(time.Second)))
+			case "LPUSH":
+				mu.Lock()
+				defer mu.Unlock()
+				if w := waiters[multi[1]]; len(w) != 0 {
+					waiters[multi[1]] = w[1:]
+					w[0] <- multi[2]
+				} else {
+					lists[multi[1]] = append([]string{multi[2]}, lists[multi[1]]...)
+				}
+				return ":" + strconv.Itoa(len(lists[multi[1]])) + "\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+}
+
+func TestBlockingCommands(t *testing.T) {
+	var tests = []struct {
+		args     string
+		blocking bool
+		timeout  time.Duration
+	}{
+		{"BLPOP q 1.5", true, time.Millisecond * 1500},
+		{"BRPOPLPUSH a b 2", true, time.Second * 2},
+		{"BLMOVE a b LEFT RIGHT 0", true, 0},
+		{"BZPOPMIN z 1", true, time.Second},
+		{"WAIT 1 200", true, time.Millisecond * 200},
+		{"XREAD COUNT 1 BLOCK 300 STREAMS s $", true, time.Millisecond * 300},
+		{"XREADGROUP GROUP g c BLOCK 0 STREAMS s >", true, 0},
+		{"XREAD STREAMS block 0", false, 0},
+		{"XREAD COUNT 1 STREAMS s 0", false, 0},
+		{"LPOP q", false, 0},
+	}
+	for _, test := range tests {
+		r := newTestRequest(strings.Fields(test.args)...)
+		assert.Must(isBlocking(r) == test.blocking)
+		if test.blocking {
+			timeout, err := blockingTimeout(r)
+			assert.MustNoError(err)
+			assert.Must(timeout == test.timeout)
+		}
+	}
+	_, err := blockingTimeout(newTestRequest("WAIT", "1", "0.5"))
+	assert.Must(err != nil)
+	_, err = blockingTimeout(newTestRequest("XREAD", "BLOCK", "-1", "STREAMS", "s", "$"))
+	assert.Must(err != nil)
+}
+
+func TestClientBlocking(t *testing.T) {
+	backend := newFakeLists()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.BackendRecvTimeout.Set(time.Second)
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c1 := dialTestConn(server.Addr())
+	defer c1.Close()
+	c2 := dialTestConn(server.Addr())
+	defer c2.Close()
+
+	expectString(c1.do("BLPOP", "q", "abc"), "ERR timeout is not a float or out of range")
+
+	// blocked longer than backend_recv_timeout, shared connections are not stalled
+	_, err := c1.Write(encodeTestRequest("BLPOP", "q", "0"))
+	assert.MustNoError(err)
+	time.Sleep(time.Second * 2)
+	assert.Must(c2.do("LPUSH", "q", "job").IsInt())
+	expectArray(c1.decode(), "q", "job")
+
+	var start = time.Now()
+	resp := c1.do("BLPOP", "q", "0.2")
+	assert.Must(resp.IsArray() && resp.Array == nil)
+	assert.Must(time.Since(start) >= time.Millisecond*200)
+
+	// disconnected client cancels the block
+	c3, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	_, err = c3.Write(encodeTestRequest("BLPOP", "q", "0"))
+	assert.MustNoError(err)
+	time.Sleep(time.Millisecond * 100)
+	assert.Must(server.clients.Len() == 3)
+	c3.Close()
+	for i := 0; server.clients.Len() != 2; i++ {
+		assert.Must(i < 100)
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -1,10 +1,17 @@
 package proxy
 
 import (
-	"net"
-	"bufio"
-	"log"
 	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/math2"
+	"SSAWPROXY/redisProxy/utils/sync2/atomic2"
 )
 
 /*
@@ -12,18 +19,60 @@ import (
  */
 
 type Client struct {
-	conn		net.Conn
+	conn		*redis.Conn	// decode requests from & encode replies to redis-cli
 	server		*Server
-	reader		*bufio.Reader
-	writer		*bufio.Writer
-	bufferSize	int
+	id		int64		// unique id of the session, see admin api
+	created		time.Time
+	active		atomic2.Int64	// unix nano of the last request
+	ops		atomic2.Int64	// requests read from the session
+
+	quit		atomic2.Bool	// set by shutdown, loop exits after in-flight request
+
+	user		string		// proxy user of the session, set by AUTH
+	authorized	bool
+	database	int		// set by SELECT, applied on backend checkout
+	mu		sync.Mutex	// guards writes of user & database, read by admin api
+
+	pubsub		*pubsub		// subscription connections, created by loopWriter
+	push		chan *redis.Resp	// replies & messages of subscriptions
+	subscribed	atomic2.Bool	// subscriber mode
+	subscriptions	subscriptions	// counted by loopReader, see pubsub.go
+
+	txn		transaction	// MULTI/WATCH state, used by loopReader
+	blocker		*blocker	// dedicated connection of blocking commands, used by loopReader
+}
+
+const (
+	sessionFlushMaxInterval = time.Microsecond * 300
+	sessionFlushMaxBuffered = 256
+	sessionMaxPush          = 256
+)
+
+func NewClient(sock net.Conn, config *Config) *Client {
+	c := redis.NewConn(sock,
+		config.SessionRecvBufsize.Int(),
+		config.SessionSendBufsize.Int(),
+	)
+	c.ReaderTimeout = config.SessionRecvTimeout.Get()
+	c.WriterTimeout = config.SessionSendTimeout.Get()
+	client := &Client{
+		conn: c,
+		created: time.Now(),
+		push: make(chan *redis.Resp, sessionMaxPush),
+	}
+	client.active.Set(client.created.UnixNano())
+	return client
 }
 
 /*
 	client conn
  */
 func (client *Client) Conn() net.Conn{
-	return client.conn
+	return client.conn.Sock
+}
+
+func (client *Client) RemoteAddr() string {
+	return client.conn.RemoteAddr()
 }
 
 
@@ -31,124 +80,273 @@ func (client *Client) Conn() net.Conn{
 	close conn
  */
 func (client *Client) Close() error{
-	err := client.conn.Close()
-	if err != nil{
-		return nil
-	}
-	return err
+	return client.conn.Close()
 }
 
 /*
-	send bytes to redis-cli
+	stop the client loop after its in-flight request,
+	closing the read side makes a blocking decode return EOF
+	while replies can still be written
  */
-func (client *Client) SendBytes(b []byte) error {
-	_, err := client.conn.Write(b)
-	if err != nil{
-		log.Fatal(err)
-	}
-	return nil
+func (client *Client) shutdown() {
+	client.quit.Set(true)
+	client.conn.CloseReader()
 }
 
 /*
-	read a line from client buffer
+	read a request from client, inline commands are decoded as multi bulk
  */
-func (client *Client) readLine() ([]byte, error) {
-	message, err := client.reader.ReadSlice('\n')
-	if err != nil {
-		client.conn.Close()
-	}
-	i := len(message) - 2
-	if i < 0 || message[i] != '\r' {
-		return nil, err
-	}
-	return message[:i], err
+func (client *Client) readRequest() ([]*redis.Resp, error) {
+	return client.conn.DecodeMultiBulk()
 }
 
 /*
-	get message size
+	serve the client session until it quits:
+	loopReader decodes & dispatches requests without waiting for replies,
+	loopWriter replies in the original order.
+	client EOF is a normal exit and returns nil.
  */
-func (client *Client) peek() (int, error) {
-	client.reader.Peek(1)
-	length := client.reader.Buffered()
-	return length, nil
+func (client *Client) serve(f *commandFilter, maxPipeline int) error {
+	tasks := make(chan *Request, maxPipeline)
+	defer func() {
+		if client.pubsub != nil {
+			client.pubsub.Close()
+		}
+		client.resetTransaction()
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		defer close(tasks)
+		errc <- client.loopReader(tasks, f)
+		// 客户端断开, 取消阻塞中的命令
+		if client.blocker != nil {
+			client.blocker.Close()
+		}
+	}()
+
+	if err := client.loopWriter(tasks); err != nil {
+		// 关闭连接使loopReader退出, 并丢弃剩余请求
+		client.Close()
+		for range tasks {
+		}
+		return err
+	}
+	if err := <-errc; err != nil && errors.Cause(err) != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func (client *Client) loopReader(tasks chan<- *Request, f *commandFilter) error {
+	for !client.quit.Get() {
+		// 从客户端接收一个完整的请求
+		multi, err := client.readRequest()
+		if err != nil {
+			if client.quit.Get() {
+				return nil
+			}
+			return err
+		}
+		client.active.Set(time.Now().UnixNano())
+		client.ops.Incr()
+		r, err := client.handleRequest(multi, f)
+		if err != nil {
+			return err
+		}
+		// 事务中任何命令出错, EXEC时放弃事务; 嵌套MULTI和MULTI中的WATCH与redis一样不影响事务
+		if client.txn.multi && r.Resp != nil && r.Resp.IsError() && r.OpStr != "MULTI" && r.OpStr != "WATCH" {
+			client.txn.dirty = true
+		}
+		tasks <- r
+		if r.OpStr == "QUIT" {
+			return nil
+		}
+	}
+	return nil
 }
 
 /*
-	read all message
+	handle a single request:
+	1.AUTH, HELLO & QUIT are handled by proxy, others require an authorized session
+	2.check arity by command table
+	3.filter unsupported commands
+	4.check acl of the session user
+	5.transactions are queued by proxy until EXEC, see transaction.go,
+	  blocking commands are sent on a dedicated connection, see blocking.go
+	6.COMMAND, SELECT, PING, ECHO & SLOWLOG are replied by proxy, pub/sub commands are sent by loopWriter,
+	  others are dispatched to backend redis
  */
-func (client *Client) readAll() ([]byte, error){
-	msgLen, err := client.peek()
+func (client *Client) handleRequest(multi []*redis.Resp, f *commandFilter) (*Request, error) {
+	//引入工具类
+	var utils utils
+
+	opstr, err := utils.opStr(multi)
 	if err != nil {
-		log.Fatal(err)
+		r := NewRequest(multi, "")
+		r.Resp = redis.NewErrorf("ERR %s", err)
+		return r, nil
 	}
-	b := make([]byte, msgLen)
-	n, err := client.reader.Read(b)
-	if err != nil {
-		log.Fatal(err)
+	r := NewRequest(multi, opstr)
+	r.Database = client.database
+
+	if opstr == "AUTH" {
+		r.Resp = client.handleAuth(r)
+		return r, nil
+	}
+	if opstr == "HELLO" {
+		r.Resp = client.handleHello(r)
+		return r, nil
+	}
+	if opstr == "QUIT" {
+		// 只关闭客户端, 回复后loopReader退出
+		r.Resp = redis.NewString([]byte("OK"))
+		return r, nil
 	}
-	return b[:n], err
+	if !client.authorized {
+		r.Resp = redis.NewErrorf("NOAUTH Authentication required.")
+		return r, nil
+	}
+	if c := commandTable[opstr]; c != nil && !c.checkArity(len(multi)) {
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for '%s' command", strings.ToLower(opstr))
+		return r, nil
+	}
+
+	// 过滤不支持的命令
+	if f.Has(opstr) {
+		// 返回错误信息
+		r.Resp = redis.NewErrorf("ERR the command '%s' is not supported", strings.ToLower(opstr))
+		return r, nil
+	}
+	if resp := client.server.acl.Check(client.user, r); resp != nil {
+		r.Resp = resp
+		return r, nil
+	}
+	if (client.txn.multi || transactionCommands[opstr]) && !client.subscribed.Get() {
+		client.handleTransaction(r)
+		return r, nil
+	}
+	if opstr == "COMMAND" {
+		r.Resp = handleCommand(multi)
+		return r, nil
+	}
+	if pubsubCommands[opstr] || (opstr == "PING" && client.subscribed.Get()) {
+		client.subscriptions.update(r)
+		client.subscribed.Set(client.subscriptions.count() != 0)
+		r.PubSub = true
+		return r, nil
+	}
+	if client.subscribed.Get() {
+		r.Resp = redis.NewErrorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context", strings.ToLower(opstr))
+		return r, nil
+	}
+	if resp := client.handleLocal(r); resp != nil {
+		r.Resp = resp
+		return r, nil
+	}
+	if isBlocking(r) {
+		client.dispatchBlocking(r)
+		return r, nil
+	}
+	if err := client.server.router.dispatch(r); err != nil {
+		log.WarnErrorf(err, "client [%s] dispatch %s failed", client.RemoteAddr(), opstr)
+		r.Resp = redis.NewErrorf("ERR backend unavailable, %s", err)
+	}
+	return r, nil
 }
 
+func (client *Client) loopWriter(tasks <-chan *Request) error {
+	p := client.conn.FlushEncoder()
+	p.MaxInterval = sessionFlushMaxInterval
+	p.MaxBuffered = math2.MinInt(sessionFlushMaxBuffered, cap(tasks))
 
-/*
-	read messages based on readLine()
- */
-func (client *Client) readReply() (interface{}, error) {
-	var utils utils		//引入工具类
-	line, err := client.readLine()
-	if err != nil {
-		return nil, err
-	}
-	if len(line) == 0 {
-		return nil, protocolError("short response line")
-	}
-	switch line[0] {
-	case '-':
-		return string(line[1:]), nil
-	case ':':
-		return utils.parseInt(line[1:])
-	case '$':
-		n, err := utils.parseLen(line[1:])
-		if n < 0 || err != nil {
-			return nil, err
+	for {
+		var resp *redis.Resp
+		var r *Request
+		select {
+		case task, ok := <-tasks:
+			if !ok {
+				return p.Flush(true)
+			}
+			if r = task; r.PubSub {
+				// 订阅命令的回复由订阅连接推送
+				if err := client.handlePubSub(r, p); err != nil {
+					return err
+				}
+				break
+			}
+			var err error
+			if resp, err = client.handleResponse(r); err != nil {
+				return err
+			}
+			client.server.stats.incr(r, resp)
+		case resp = <-client.push:
 		}
-		p := make([]byte, n)
-		_, err = io.ReadFull(client.reader, p)
-		if err != nil {
-			return nil, err
+		if resp != nil && resp != pubsubSync {
+			if err := p.Encode(resp); err != nil {
+				return err
+			}
 		}
-		if line, err := client.readLine(); err != nil {
-			return nil, err
-		}else if len(line) != 0 {
-			return nil, protocolError("bad bulk string format")
+		if err := p.Flush(len(tasks) == 0 && len(client.push) == 0); err != nil {
+			return err
 		}
-		return p, nil
-	case '*':
-		n, err := utils.parseLen(line[1:])
-		if n < 0 || err != nil {
-			return nil, err
+		if r != nil && !r.PubSub {
+			client.server.slowlog.record(client, r)
 		}
-		r := make([]interface{}, n)
-		for i := range r {
-			r[i], err = client.readReply()
-			if err != nil {
-				return nil, err
-			}
+		if r != nil && client.server.accessLog != nil {
+			client.server.accessLog.record(client, r, resp)
 		}
-		return r, nil
 	}
-	return nil, protocolError("unexpected response line")
 }
 
 /*
-	返回处理完的数据
+	send a subscription command and write its replies
+	lost subscription connection closes the session
+ */
+func (client *Client) handlePubSub(r *Request, p *redis.FlushEncoder) error {
+	if client.pubsub == nil {
+		client.pubsub = newPubSub(client)
+	}
+	err := client.pubsub.handle(r, p)
+	switch {
+	case err == nil:
+		return nil
+	case err == ErrLostPubSub || err == ErrClosedPubSub:
+		return err
+	default:
+		log.WarnErrorf(err, "client [%s] %s failed", client.RemoteAddr(), r.OpStr)
+		return p.Encode(redis.NewErrorf("ERR backend unavailable, %s", err))
+	}
+}
+
+/*
+	push a reply of subscriptions to loopWriter, false if exit is closed
  */
-func (client *Client) Receive() (reply interface{}, err error) {
-	if reply, err = client.readReply(); err != nil{
-		return nil, err
+func (client *Client) pushResp(resp *redis.Resp, exit <-chan struct{}) bool {
+	select {
+	case client.push <- resp:
+		return true
+	case <-exit:
+		return false
 	}
-	if err, ok := reply.(error); ok{
-		return nil, err
+}
+
+/*
+	wait for the reply of a request
+	兼容集群模式, follow -MOVED/-ASK to the target node
+	backend errors are returned to client as -ERR replies
+ */
+func (client *Client) handleResponse(r *Request) (*redis.Resp, error) {
+	resp, err := client.server.router.handleResponse(r)
+	if err != nil {
+		return redis.NewErrorf("ERR handle response, %s", err), nil
 	}
-	return
-}
\ No newline at end of file
+	return resp, nil
+}
+
+/*
+	send reply to redis-cli
+ */
+func (client *Client) SendResp(resp *redis.Resp) error {
+	return client.conn.Encode(resp, true)
+}
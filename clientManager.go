@@ -1,7 +1,73 @@
 package proxy
 
+import (
+	"sync"
+)
+
+/*
+	clientManager: track all alive client sessions of a server
+ */
+
 type ClientManager struct {
-	client *[]Client
-	addr   []string
-	remove func(address string)(error)
-}
\ No newline at end of file
+	mu	sync.Mutex
+	clients	map[*Client]struct{}
+	wait	sync.WaitGroup
+}
+
+/*
+	register a client session
+ */
+func (manager *ClientManager) add(client *Client) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.clients == nil {
+		manager.clients = make(map[*Client]struct{})
+	}
+	manager.clients[client] = struct{}{}
+	manager.wait.Add(1)
+}
+
+/*
+	unregister a client session after its loop exits
+ */
+func (manager *ClientManager) remove(client *Client) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if _, ok := manager.clients[client]; ok {
+		delete(manager.clients, client)
+		manager.wait.Done()
+	}
+}
+
+/*
+	number of alive client sessions
+ */
+func (manager *ClientManager) Len() int {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return len(manager.clients)
+}
+
+/*
+	snapshot of alive client sessions
+ */
+func (manager *ClientManager) List() []*Client {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	list := make([]*Client, 0, len(manager.clients))
+	for client := range manager.clients {
+		list = append(list, client)
+	}
+	return list
+}
+
+/*
+	ask every client session to stop after its in-flight request,
+	then wait until all of them exit
+ */
+func (manager *ClientManager) drain() {
+	for _, client := range manager.List() {
+		client.shutdown()
+	}
+	manager.wait.Wait()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	proxy "SSAWPROXY/redisProxy"
+	"SSAWPROXY/redisProxy/utils/log"
+)
+
+/*
+	main.go: ssawproxy entrypoint
+	1.load config file, command line flags override the file
+	2.start server
+	3.SIGINT/SIGTERM: stop accepting, drain clients, close backends and exit
+	4.SIGHUP: reload session users and acl rules from config file
+ */
+
+const usage = `Usage:
+	ssawproxy [--config=CONF] [--log=FILE] [--log-level=LEVEL] [OPTIONS]
+	ssawproxy --default-config
+	ssawproxy --hash-password=USER:PASSWORD
+	ssawproxy --admin-xauth=AUTH
+`
+
+func main() {
+	config := proxy.NewDefaultConfig()
+
+	fs := flag.NewFlagSet("ssawproxy", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage, "\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	var (
+		configFile    = fs.String("config", "", "path of toml config file")
+		logFile       = fs.String("log", "", "path of log file, default is stderr")
+		logRolling    = fs.String("log-rolling", "daily", "rolling format of log file: hourly or daily")
+		logLevel      = fs.String("log-level", "info", "log level: debug, info, warn or error")
+		defaultConfig = fs.Bool("default-config", false, "print default config and exit")
+		hashPassword  = fs.String("hash-password", "", "print password hash of USER:PASSWORD for session_users and exit")
+		adminXAuth    = fs.String("admin-xauth", "", "print xauth token of admin api for admin_auth and exit")
+	)
+	config.RegisterFlags(fs)
+	fs.Parse(os.Args[1:])
+
+	if *defaultConfig {
+		fmt.Print(proxy.DefaultConfig)
+		return
+	}
+	if *hashPassword != "" {
+		p := strings.SplitN(*hashPassword, ":", 2)
+		if len(p) != 2 {
+			log.Panicf("invalid hash-password = %s, should be USER:PASSWORD", *hashPassword)
+		}
+		fmt.Println(proxy.HashPassword(p[0], p[1]))
+		return
+	}
+	if *adminXAuth != "" {
+		fmt.Println(proxy.AdminXAuth(*adminXAuth))
+		return
+	}
+
+	// 配置文件优先加载, 命令行参数覆盖配置文件
+	if *configFile != "" {
+		if err := config.LoadFromFile(*configFile); err != nil {
+			log.PanicErrorf(err, "load config %s failed", *configFile)
+		}
+		fs.Parse(os.Args[1:])
+	}
+
+	if *logFile != "" {
+		var rolling log.RollingFormat = log.DailyRolling
+		if *logRolling == "hourly" {
+			rolling = log.HourlyRolling
+		}
+		w, err := log.NewRollingFile(*logFile, rolling)
+		if err != nil {
+			log.PanicErrorf(err, "open log file %s failed", *logFile)
+		}
+		log.StdLog = log.New(w, "")
+	}
+	if !log.SetLevelString(*logLevel) {
+		log.Panicf("invalid log level = %s", *logLevel)
+	}
+
+	server, err := proxy.NewServer(config)
+	if err != nil {
+		log.PanicErrorf(err, "create server failed")
+	}
+	log.Warnf("create proxy with config\n%s", config)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Listen()
+	}()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	var code int
+	for exit := false; !exit; {
+		select {
+		case sig := <-c:
+			if sig == syscall.SIGHUP {
+				reloadACL(server, *configFile)
+				continue
+			}
+			log.Warnf("[%p] proxy receive signal = '%v', shutting down", server, sig)
+		case err := <-errc:
+			log.ErrorErrorf(err, "[%p] proxy listen on %s failed", server, config.ProxyAddr)
+			code = 1
+		}
+		exit = true
+	}
+	signal.Stop(c)
+
+	if err := server.Close(); err != nil {
+		log.ErrorErrorf(err, "[%p] proxy shutdown failed", server)
+		code = 1
+	} else {
+		log.Warnf("[%p] proxy is exiting", server)
+	}
+	log.StdLog.Close()
+	os.Exit(code)
+}
+
+/*
+	reload session users and acl rules, the running config is kept on error
+ */
+func reloadACL(server *proxy.Server, path string) {
+	if path == "" {
+		log.Warnf("[%p] proxy reload acl skipped, no config file", server)
+		return
+	}
+	config := proxy.NewDefaultConfig()
+	if err := config.LoadFromFile(path); err != nil {
+		log.WarnErrorf(err, "[%p] proxy reload acl failed", server)
+		return
+	}
+	if err := config.Validate(); err != nil {
+		log.WarnErrorf(err, "[%p] proxy reload acl failed", server)
+		return
+	}
+	if err := server.ReloadACL(config.SessionUsers, config.SessionDefaultRules); err != nil {
+		log.WarnErrorf(err, "[%p] proxy reload acl failed", server)
+		return
+	}
+	log.Warnf("[%p] proxy reload acl, %d users", server, len(config.SessionUsers))
+}
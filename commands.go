@@ -0,0 +1,438 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+)
+
+/*
+	command table of redis
+	1.arity: positive is the exact number of arguments, negative is the minimum
+	2.flags: write, readonly, admin, ... as redis COMMAND reports
+	  dangerous is only used by acl category @dangerous
+	3.key positions: first key, last key (negative counts from the end) and step
+	4.used by routing, acl and arity check, COMMAND is replied by proxy from it
+	commands missing in the table are forwarded to backend without arity check,
+	acl denies them for users restricted by key patterns as their keys are unknown
+ */
+
+type commandFlag uint
+
+const (
+	flagWrite commandFlag = 1 << iota
+	flagReadOnly
+	flagDenyOOM
+	flagAdmin
+	flagPubSub
+	flagNoScript
+	flagRandom
+	flagSortForScript
+	flagLoading
+	flagStale
+	flagSkipMonitor
+	flagAsking
+	flagFast
+	flagMovableKeys
+	flagBlocking
+	flagDangerous
+)
+
+var commandFlagNames = []string{
+	"write", "readonly", "denyoom", "admin", "pubsub", "noscript", "random", "sort_for_script",
+	"loading", "stale", "skip_monitor", "asking", "fast", "movablekeys", "blocking", "dangerous",
+}
+
+type commandInfo struct {
+	Name     string
+	Arity    int
+	Flags    commandFlag
+	FirstKey int
+	LastKey  int
+	Step     int
+}
+
+func (c *commandInfo) hasFlag(flag commandFlag) bool {
+	return c.Flags&flag != 0
+}
+
+func (c *commandInfo) checkArity(n int) bool {
+	if c.Arity >= 0 {
+		return n == c.Arity
+	}
+	return n >= -c.Arity
+}
+
+var (
+	commandTable = make(map[string]*commandInfo)
+	commandList  []*commandInfo
+)
+
+func init() {
+	for _, c := range []struct {
+		name  string
+		arity int
+		flags string
+		first int
+		last  int
+		step  int
+	}{
+		// keys
+		{"DEL", -2, "write", 1, -1, 1},
+		{"UNLINK", -2, "write fast", 1, -1, 1},
+		{"DUMP", 2, "readonly", 1, 1, 1},
+		{"EXISTS", -2, "readonly fast", 1, -1, 1},
+		{"EXPIRE", 3, "write fast", 1, 1, 1},
+		{"EXPIREAT", 3, "write fast", 1, 1, 1},
+		{"KEYS", 2, "readonly sort_for_script dangerous", 0, 0, 0},
+		{"MIGRATE", -6, "write movablekeys dangerous", 0, 0, 0},
+		{"MOVE", 3, "write fast", 1, 1, 1},
+		{"OBJECT", -2, "readonly", 2, 2, 1},
+		{"PERSIST", 2, "write fast", 1, 1, 1},
+		{"PEXPIRE", 3, "write fast", 1, 1, 1},
+		{"PEXPIREAT", 3, "write fast", 1, 1, 1},
+		{"PTTL", 2, "readonly fast", 1, 1, 1},
+		{"RANDOMKEY", 1, "readonly random", 0, 0, 0},
+		{"RENAME", 3, "write", 1, 2, 1},
+		{"RENAMENX", 3, "write fast", 1, 2, 1},
+		{"RESTORE", -4, "write denyoom dangerous", 1, 1, 1},
+		{"SCAN", -2, "readonly random", 0, 0, 0},
+		{"SORT", -2, "write denyoom movablekeys dangerous", 1, 1, 1},
+		{"TOUCH", -2, "readonly fast", 1, -1, 1},
+		{"TTL", 2, "readonly fast", 1, 1, 1},
+		{"TYPE", 2, "readonly fast", 1, 1, 1},
+		{"COPY", -3, "write denyoom", 1, 2, 1},
+		{"WAIT", 3, "noscript blocking", 0, 0, 0},
+		// strings
+		{"APPEND", 3, "write denyoom", 1, 1, 1},
+		{"BITCOUNT", -2, "readonly", 1, 1, 1},
+		{"BITFIELD", -2, "write denyoom", 1, 1, 1},
+		{"BITOP", -4, "write denyoom", 2, -1, 1},
+		{"BITPOS", -3, "readonly", 1, 1, 1},
+		{"DECR", 2, "write denyoom fast", 1, 1, 1},
+		{"DECRBY", 3, "write denyoom fast", 1, 1, 1},
+		{"GET", 2, "readonly fast", 1, 1, 1},
+		{"GETBIT", 3, "readonly fast", 1, 1, 1},
+		{"GETDEL", 2, "write fast", 1, 1, 1},
+		{"GETEX", -2, "write fast", 1, 1, 1},
+		{"GETRANGE", 4, "readonly", 1, 1, 1},
+		{"GETSET", 3, "write denyoom", 1, 1, 1},
+		{"INCR", 2, "write denyoom fast", 1, 1, 1},
+		{"INCRBY", 3, "write denyoom fast", 1, 1, 1},
+		{"INCRBYFLOAT", 3, "write denyoom fast", 1, 1, 1},
+		{"MGET", -2, "readonly fast", 1, -1, 1},
+		{"MSET", -3, "write denyoom", 1, -1, 2},
+		{"MSETNX", -3, "write denyoom", 1, -1, 2},
+		{"PSETEX", 4, "write denyoom", 1, 1, 1},
+		{"SET", -3, "write denyoom", 1, 1, 1},
+		{"SETBIT", 4, "write denyoom", 1, 1, 1},
+		{"SETEX", 4, "write denyoom", 1, 1, 1},
+		{"SETNX", 3, "write denyoom fast", 1, 1, 1},
+		{"SETRANGE", 4, "write denyoom", 1, 1, 1},
+		{"STRLEN", 2, "readonly fast", 1, 1, 1},
+		{"SUBSTR", 4, "readonly", 1, 1, 1},
+		// hashes
+		{"HDEL", -3, "write fast", 1, 1, 1},
+		{"HEXISTS", 3, "readonly fast", 1, 1, 1},
+		{"HGET", 3, "readonly fast", 1, 1, 1},
+		{"HGETALL", 2, "readonly random", 1, 1, 1},
+		{"HINCRBY", 4, "write denyoom fast", 1, 1, 1},
+		{"HINCRBYFLOAT", 4, "write denyoom fast", 1, 1, 1},
+		{"HKEYS", 2, "readonly sort_for_script", 1, 1, 1},
+		{"HLEN", 2, "readonly fast", 1, 1, 1},
+		{"HMGET", -3, "readonly fast", 1, 1, 1},
+		{"HMSET", -4, "write denyoom fast", 1, 1, 1},
+		{"HSCAN", -3, "readonly random", 1, 1, 1},
+		{"HSET", -4, "write denyoom fast", 1, 1, 1},
+		{"HSETNX", 4, "write denyoom fast", 1, 1, 1},
+		{"HSTRLEN", 3, "readonly fast", 1, 1, 1},
+		{"HVALS", 2, "readonly sort_for_script", 1, 1, 1},
+		// lists
+		{"BLPOP", -3, "write noscript blocking", 1, -2, 1},
+		{"BRPOP", -3, "write noscript blocking", 1, -2, 1},
+		{"BRPOPLPUSH", 4, "write denyoom noscript blocking", 1, 2, 1},
+		{"BLMOVE", 6, "write denyoom noscript blocking", 1, 2, 1},
+		{"LINDEX", 3, "readonly", 1, 1, 1},
+		{"LINSERT", 5, "write denyoom", 1, 1, 1},
+		{"LLEN", 2, "readonly fast", 1, 1, 1},
+		{"LMOVE", 5, "write denyoom", 1, 2, 1},
+		{"LPOS", -3, "readonly", 1, 1, 1},
+		{"LPOP", 2, "write fast", 1, 1, 1},
+		{"LPUSH", -3, "write denyoom fast", 1, 1, 1},
+		{"LPUSHX", -3, "write denyoom fast", 1, 1, 1},
+		{"LRANGE", 4, "readonly", 1, 1, 1},
+		{"LREM", 4, "write", 1, 1, 1},
+		{"LSET", 4, "write denyoom", 1, 1, 1},
+		{"LTRIM", 4, "write", 1, 1, 1},
+		{"RPOP", 2, "write fast", 1, 1, 1},
+		{"RPOPLPUSH", 3, "write denyoom", 1, 2, 1},
+		{"RPUSH", -3, "write denyoom fast", 1, 1, 1},
+		{"RPUSHX", -3, "write denyoom fast", 1, 1, 1},
+		// sets
+		{"SADD", -3, "write denyoom fast", 1, 1, 1},
+		{"SCARD", 2, "readonly fast", 1, 1, 1},
+		{"SDIFF", -2, "readonly sort_for_script", 1, -1, 1},
+		{"SDIFFSTORE", -3, "write denyoom", 1, -1, 1},
+		{"SINTER", -2, "readonly sort_for_script", 1, -1, 1},
+		{"SINTERSTORE", -3, "write denyoom", 1, -1, 1},
+		{"SISMEMBER", 3, "readonly fast", 1, 1, 1},
+		{"SMISMEMBER", -3, "readonly fast", 1, 1, 1},
+		{"SMEMBERS", 2, "readonly sort_for_script", 1, 1, 1},
+		{"SMOVE", 4, "write fast", 1, 2, 1},
+		{"SPOP", -2, "write random fast", 1, 1, 1},
+		{"SRANDMEMBER", -2, "readonly random", 1, 1, 1},
+		{"SREM", -3, "write fast", 1, 1, 1},
+		{"SSCAN", -3, "readonly random", 1, 1, 1},
+		{"SUNION", -2, "readonly sort_for_script", 1, -1, 1},
+		{"SUNIONSTORE", -3, "write denyoom", 1, -1, 1},
+		// sorted sets
+		{"BZPOPMAX", -3, "write noscript blocking fast", 1, -2, 1},
+		{"BZPOPMIN", -3, "write noscript blocking fast", 1, -2, 1},
+		{"ZADD", -4, "write denyoom fast", 1, 1, 1},
+		{"ZCARD", 2, "readonly fast", 1, 1, 1},
+		{"ZCOUNT", 4, "readonly fast", 1, 1, 1},
+		{"ZINCRBY", 4, "write denyoom fast", 1, 1, 1},
+		{"ZINTERSTORE", -4, "write denyoom movablekeys", 0, 0, 0},
+		{"ZLEXCOUNT", 4, "readonly fast", 1, 1, 1},
+		{"ZMSCORE", -3, "readonly fast", 1, 1, 1},
+		{"ZPOPMAX", -2, "write fast", 1, 1, 1},
+		{"ZPOPMIN", -2, "write fast", 1, 1, 1},
+		{"ZRANGE", -4, "readonly", 1, 1, 1},
+		{"ZRANGEBYLEX", -4, "readonly", 1, 1, 1},
+		{"ZRANGEBYSCORE", -4, "readonly", 1, 1, 1},
+		{"ZRANK", 3, "readonly fast", 1, 1, 1},
+		{"ZREM", -3, "write fast", 1, 1, 1},
+		{"ZREMRANGEBYLEX", 4, "write", 1, 1, 1},
+		{"ZREMRANGEBYRANK", 4, "write", 1, 1, 1},
+		{"ZREMRANGEBYSCORE", 4, "write", 1, 1, 1},
+		{"ZREVRANGE", -4, "readonly", 1, 1, 1},
+		{"ZREVRANGEBYLEX", -4, "readonly", 1, 1, 1},
+		{"ZREVRANGEBYSCORE", -4, "readonly", 1, 1, 1},
+		{"ZREVRANK", 3, "readonly fast", 1, 1, 1},
+		{"ZSCAN", -3, "readonly random", 1, 1, 1},
+		{"ZSCORE", 3, "readonly fast", 1, 1, 1},
+		{"ZUNIONSTORE", -4, "write denyoom movablekeys", 0, 0, 0},
+		// streams
+		{"XACK", -4, "write fast", 1, 1, 1},
+		{"XADD", -5, "write denyoom random fast", 1, 1, 1},
+		{"XAUTOCLAIM", -6, "write random fast", 1, 1, 1},
+		{"XCLAIM", -6, "write random fast", 1, 1, 1},
+		{"XDEL", -3, "write fast", 1, 1, 1},
+		{"XGROUP", -2, "write denyoom", 2, 2, 1},
+		{"XINFO", -2, "readonly random", 2, 2, 1},
+		{"XLEN", 2, "readonly fast", 1, 1, 1},
+		{"XPENDING", -3, "readonly random", 1, 1, 1},
+		{"XRANGE", -4, "readonly", 1, 1, 1},
+		{"XREAD", -4, "readonly movablekeys", 0, 0, 0},
+		{"XREADGROUP", -7, "write movablekeys", 0, 0, 0},
+		{"XREVRANGE", -4, "readonly", 1, 1, 1},
+		{"XSETID", 3, "write denyoom", 1, 1, 1},
+		{"XTRIM", -2, "write random", 1, 1, 1},
+		// hyperloglog & geo
+		{"PFADD", -2, "write denyoom fast", 1, 1, 1},
+		{"PFCOUNT", -2, "readonly", 1, -1, 1},
+		{"PFMERGE", -2, "write denyoom", 1, -1, 1},
+		{"GEOADD", -5, "write denyoom", 1, 1, 1},
+		{"GEODIST", -4, "readonly", 1, 1, 1},
+		{"GEOHASH", -2, "readonly", 1, 1, 1},
+		{"GEOPOS", -2, "readonly", 1, 1, 1},
+		{"GEORADIUS", -6, "write movablekeys", 1, 1, 1},
+		{"GEORADIUSBYMEMBER", -5, "write movablekeys", 1, 1, 1},
+		{"GEORADIUS_RO", -6, "readonly", 1, 1, 1},
+		{"GEORADIUSBYMEMBER_RO", -5, "readonly", 1, 1, 1},
+		// pub/sub
+		{"PSUBSCRIBE", -2, "pubsub noscript loading stale", 0, 0, 0},
+		{"PUBLISH", 3, "pubsub loading stale fast", 0, 0, 0},
+		{"PUBSUB", -2, "pubsub random loading stale", 0, 0, 0},
+		{"PUNSUBSCRIBE", -1, "pubsub noscript loading stale", 0, 0, 0},
+		{"SUBSCRIBE", -2, "pubsub noscript loading stale", 0, 0, 0},
+		{"UNSUBSCRIBE", -1, "pubsub noscript loading stale", 0, 0, 0},
+		{"SPUBLISH", 3, "pubsub loading stale fast", 0, 0, 0},
+		{"SSUBSCRIBE", -2, "pubsub noscript loading stale", 0, 0, 0},
+		{"SUNSUBSCRIBE", -1, "pubsub noscript loading stale", 0, 0, 0},
+		// transactions & scripting
+		{"DISCARD", 1, "noscript fast", 0, 0, 0},
+		{"EXEC", 1, "noscript skip_monitor", 0, 0, 0},
+		{"MULTI", 1, "noscript fast", 0, 0, 0},
+		{"UNWATCH", 1, "noscript fast", 0, 0, 0},
+		{"WATCH", -2, "noscript fast", 1, -1, 1},
+		{"EVAL", -3, "noscript movablekeys", 0, 0, 0},
+		{"EVALSHA", -3, "noscript movablekeys", 0, 0, 0},
+		{"SCRIPT", -2, "noscript", 0, 0, 0},
+		// connection
+		{"AUTH", -2, "noscript loading stale fast", 0, 0, 0},
+		{"ECHO", 2, "fast", 0, 0, 0},
+		{"HELLO", -1, "noscript loading stale fast", 0, 0, 0},
+		{"PING", -1, "stale fast", 0, 0, 0},
+		{"QUIT", 1, "loading stale fast", 0, 0, 0},
+		{"RESET", 1, "noscript loading stale fast", 0, 0, 0},
+		{"SELECT", 2, "loading fast", 0, 0, 0},
+		{"SWAPDB", 3, "write fast dangerous", 0, 0, 0},
+		// server
+		{"BGREWRITEAOF", 1, "admin dangerous", 0, 0, 0},
+		{"BGSAVE", -1, "admin dangerous", 0, 0, 0},
+		{"CLIENT", -2, "admin noscript dangerous", 0, 0, 0},
+		{"COMMAND", -1, "loading stale", 0, 0, 0},
+		{"CONFIG", -2, "admin loading stale dangerous", 0, 0, 0},
+		{"DBSIZE", 1, "readonly fast", 0, 0, 0},
+		{"DEBUG", -1, "admin noscript dangerous", 0, 0, 0},
+		{"FLUSHALL", -1, "write dangerous", 0, 0, 0},
+		{"FLUSHDB", -1, "write dangerous", 0, 0, 0},
+		{"INFO", -1, "loading stale dangerous", 0, 0, 0},
+		{"LASTSAVE", 1, "random fast dangerous", 0, 0, 0},
+		{"LATENCY", -2, "admin noscript loading stale dangerous", 0, 0, 0},
+		{"MEMORY", -2, "readonly", 0, 0, 0},
+		{"MONITOR", 1, "admin noscript dangerous", 0, 0, 0},
+		{"PSYNC", 3, "readonly admin noscript dangerous", 0, 0, 0},
+		{"REPLCONF", -1, "admin noscript loading stale dangerous", 0, 0, 0},
+		{"ROLE", 1, "noscript loading stale dangerous", 0, 0, 0},
+		{"SAVE", 1, "admin noscript dangerous", 0, 0, 0},
+		{"SHUTDOWN", -1, "admin loading stale dangerous", 0, 0, 0},
+		{"SLAVEOF", 3, "admin noscript stale dangerous", 0, 0, 0},
+		{"SLOWLOG", -2, "admin dangerous", 0, 0, 0},
+		{"SYNC", 1, "readonly admin noscript dangerous", 0, 0, 0},
+		{"TIME", 1, "random fast", 0, 0, 0},
+		// cluster
+		{"ASKING", 1, "fast", 0, 0, 0},
+		{"CLUSTER", -2, "admin dangerous", 0, 0, 0},
+		{"READONLY", 1, "fast", 0, 0, 0},
+		{"READWRITE", 1, "fast", 0, 0, 0},
+	} {
+		info := &commandInfo{
+			Name: c.name, Arity: c.arity,
+			FirstKey: c.first, LastKey: c.last, Step: c.step,
+		}
+		for _, name := range strings.Fields(c.flags) {
+			for i := range commandFlagNames {
+				if commandFlagNames[i] == name {
+					info.Flags |= 1 << uint(i)
+				}
+			}
+		}
+		commandTable[c.name] = info
+		commandList = append(commandList, info)
+	}
+}
+
+/*
+	keys of a request, movable keys of EVAL/EVALSHA and ZINTERSTORE/ZUNIONSTORE are parsed from numkeys,
+	keys of XREAD/XREADGROUP are the first half of the arguments after STREAMS
+ */
+func getKeys(multi []*redis.Resp, opstr string) [][]byte {
+	c := commandTable[opstr]
+	if c == nil {
+		return nil
+	}
+	var keys [][]byte
+	switch opstr {
+	case "EVAL", "EVALSHA", "ZINTERSTORE", "ZUNIONSTORE":
+		if len(multi) < 3 {
+			return nil
+		}
+		n, err := redis.Btoi64(multi[2].Value)
+		if err != nil || n < 0 || int(n) > len(multi)-3 {
+			return nil
+		}
+		if opstr == "ZINTERSTORE" || opstr == "ZUNIONSTORE" {
+			keys = append(keys, multi[1].Value)
+		}
+		for _, r := range multi[3 : 3+n] {
+			keys = append(keys, r.Value)
+		}
+		return keys
+	case "XREAD", "XREADGROUP":
+		for i := 1; i < len(multi); i++ {
+			if strings.ToUpper(string(multi[i].Value)) != "STREAMS" {
+				continue
+			}
+			rest := multi[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil
+			}
+			for _, r := range rest[:len(rest)/2] {
+				keys = append(keys, r.Value)
+			}
+			return keys
+		}
+		return nil
+	}
+	if c.FirstKey == 0 || c.FirstKey >= len(multi) {
+		return nil
+	}
+	last := c.LastKey
+	if last < 0 {
+		last += len(multi)
+	}
+	for i := c.FirstKey; i <= last && i < len(multi); i += c.Step {
+		keys = append(keys, multi[i].Value)
+	}
+	return keys
+}
+
+/*
+	command info reply: name, arity, flags, first key, last key, step
+ */
+func (c *commandInfo) reply() *redis.Resp {
+	var flags []*redis.Resp
+	for i, name := range commandFlagNames {
+		if flag := commandFlag(1 << uint(i)); flag != flagDangerous && c.hasFlag(flag) {
+			flags = append(flags, redis.NewString([]byte(name)))
+		}
+	}
+	return redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte(strings.ToLower(c.Name))),
+		redis.NewInt(strconv.AppendInt(nil, int64(c.Arity), 10)),
+		redis.NewArray(flags),
+		redis.NewInt(strconv.AppendInt(nil, int64(c.FirstKey), 10)),
+		redis.NewInt(strconv.AppendInt(nil, int64(c.LastKey), 10)),
+		redis.NewInt(strconv.AppendInt(nil, int64(c.Step), 10)),
+	})
+}
+
+/*
+	COMMAND, COMMAND COUNT, COMMAND INFO name [name ...], COMMAND GETKEYS command [arg ...]
+ */
+func handleCommand(multi []*redis.Resp) *redis.Resp {
+	if len(multi) == 1 {
+		var array = make([]*redis.Resp, 0, len(commandList))
+		for _, c := range commandList {
+			array = append(array, c.reply())
+		}
+		return redis.NewArray(array)
+	}
+	switch sub := strings.ToUpper(string(multi[1].Value)); {
+	case sub == "COUNT" && len(multi) == 2:
+		return redis.NewInt(strconv.AppendInt(nil, int64(len(commandList)), 10))
+	case sub == "INFO":
+		var array = make([]*redis.Resp, 0, len(multi)-2)
+		for _, name := range multi[2:] {
+			if c := commandTable[strings.ToUpper(string(name.Value))]; c != nil {
+				array = append(array, c.reply())
+			} else {
+				array = append(array, redis.NewArray(nil))
+			}
+		}
+		return redis.NewArray(array)
+	case sub == "GETKEYS" && len(multi) > 2:
+		opstr := strings.ToUpper(string(multi[2].Value))
+		c := commandTable[opstr]
+		if c == nil {
+			return redis.NewErrorf("ERR Invalid command specified")
+		}
+		if !c.checkArity(len(multi) - 2) {
+			return redis.NewErrorf("ERR Invalid number of arguments specified for command")
+		}
+		keys := getKeys(multi[2:], opstr)
+		if len(keys) == 0 {
+			return redis.NewErrorf("ERR The command has no key arguments")
+		}
+		var array = make([]*redis.Resp, 0, len(keys))
+		for _, key := range keys {
+			array = append(array, redis.NewBulkBytes(key))
+		}
+		return redis.NewArray(array)
+	default:
+		return redis.NewErrorf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try COMMAND HELP.", multi[1].Value)
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+func TestGetKeys(t *testing.T) {
+	var tests = []struct {
+		args []string
+		keys string
+	}{
+		{[]string{"GET", "a"}, "a"},
+		{[]string{"PING"}, ""},
+		{[]string{"MSET", "a", "1", "b", "2"}, "a b"},
+		{[]string{"BLPOP", "a", "b", "0"}, "a b"},
+		{[]string{"BITOP", "AND", "d", "a", "b"}, "d a b"},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "arg"}, "a b"},
+		{[]string{"EVAL", "return 1", "3", "a"}, ""},
+		{[]string{"ZUNIONSTORE", "d", "2", "a", "b", "WEIGHTS", "1", "2"}, "d a b"},
+		{[]string{"OBJECT", "ENCODING", "a"}, "a"},
+		{[]string{"LMOVE", "a", "b", "LEFT", "RIGHT"}, "a b"},
+		{[]string{"BZPOPMIN", "a", "b", "0"}, "a b"},
+		{[]string{"XREAD", "COUNT", "2", "STREAMS", "a", "b", "0", "0"}, "a b"},
+		{[]string{"XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "a", ">"}, "a"},
+		{[]string{"XREAD", "STREAMS", "a", "b", "0"}, ""},
+		{[]string{"XGROUP", "CREATE", "a", "g", "$"}, "a"},
+		{[]string{"RESET"}, ""},
+	}
+	for _, test := range tests {
+		r := newTestRequest(test.args...)
+		var keys []string
+		for _, key := range getKeys(r.Multi, r.OpStr) {
+			keys = append(keys, string(key))
+		}
+		assert.Must(strings.Join(keys, " ") == test.keys)
+	}
+}
+
+func TestCommandArity(t *testing.T) {
+	assert.Must(commandTable["GET"].checkArity(2))
+	assert.Must(!commandTable["GET"].checkArity(3))
+	assert.Must(commandTable["SET"].checkArity(5))
+	assert.Must(!commandTable["SET"].checkArity(2))
+	assert.Must(commandTable["BLPOP"].hasFlag(flagBlocking))
+	assert.Must(commandTable["FLUSHALL"].hasFlag(flagDangerous))
+}
+
+func TestHandleCommand(t *testing.T) {
+	resp := handleCommand(newTestRequest("COMMAND").Multi)
+	assert.Must(resp.IsArray() && len(resp.Array) == len(commandList))
+
+	resp = handleCommand(newTestRequest("COMMAND", "COUNT").Multi)
+	assert.Must(resp.IsInt() && string(resp.Value) == strconv.Itoa(len(commandList)))
+
+	resp = handleCommand(newTestRequest("COMMAND", "INFO", "get", "nosuchcommand").Multi)
+	assert.Must(resp.IsArray() && len(resp.Array) == 2)
+	get := resp.Array[0]
+	assert.Must(string(get.Array[0].Value) == "get" && string(get.Array[1].Value) == "2")
+	assert.Must(len(get.Array[2].Array) == 2 && string(get.Array[2].Array[0].Value) == "readonly")
+	assert.Must(resp.Array[1].IsArray() && len(resp.Array[1].Array) == 0)
+
+	resp = handleCommand(newTestRequest("COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2").Multi)
+	assert.Must(resp.IsArray() && len(resp.Array) == 2 && string(resp.Array[1].Value) == "b")
+
+	for _, args := range [][]string{
+		{"COMMAND", "GETKEYS", "PING"},
+		{"COMMAND", "GETKEYS", "GET"},
+		{"COMMAND", "NOSUCH"},
+	} {
+		assert.Must(handleCommand(newTestRequest(args...).Multi).IsError())
+	}
+}
+
+func TestClientCommand(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+
+	_, err = c.Write([]byte("*1\r\n$3\r\nGET\r\n*2\r\n$7\r\nCOMMAND\r\n$5\r\nCOUNT\r\n"))
+	assert.MustNoError(err)
+
+	d := redis.NewDecoder(c)
+	resp, err := d.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsError() && string(resp.Value) == "ERR wrong number of arguments for 'get' command")
+	resp, err = d.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsInt())
+}
@@ -0,0 +1,523 @@
+package proxy
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"SSAWPROXY/redisProxy/utils/bytesize"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/timesize"
+)
+
+/*
+	config.go: proxy configuration
+	1.toml file (DefaultConfig 为默认配置)
+	2.command line flags override the file
+	3.validate before the server starts
+ */
+
+const (
+	BackendModeStandalone = "standalone"
+	BackendModeCluster    = "cluster"
+	BackendModeSentinel   = "sentinel"
+)
+
+const DefaultConfig = `
+##################################################
+#                                                #
+#                  SSAWPROXY                     #
+#                                                #
+##################################################
+
+# Set bind address for proxy, proto_type can be "tcp", "tcp4", "tcp6", "unix" or "unixpacket".
+proto_type = "tcp4"
+proxy_addr = "0.0.0.0:19000"
+
+# Set bind address of http admin listener, GET /metrics exports prometheus metrics. Empty means disabled.
+# Requests of /api/ must carry ?xauth=TOKEN, run "ssawproxy --admin-xauth=AUTH" for the token.
+# The /api/ endpoints are disabled if admin_auth is empty, /metrics is always served.
+admin_addr = ""
+admin_auth = ""
+
+# Set backend mode, can be "standalone", "cluster" or "sentinel".
+backend_mode = "standalone"
+
+# Set backend redis addresses.
+#   standalone : the redis server, e.g. ["127.0.0.1:6379"]
+#   cluster    : seed nodes of the redis cluster
+#   sentinel   : addresses of the sentinels
+backend_addrs = ["127.0.0.1:6379"]
+
+# Set redis auth password for backend connections, empty means no AUTH.
+backend_auth = ""
+
+# Set TLS of backend connections, applied to redis nodes, cluster redirect targets, replicas and sentinels.
+#   backend_tls_ca_file     : CA bundle to verify servers, empty means system roots
+#   backend_tls_server_name : SNI & name to verify, empty means host of the address
+#   backend_tls_cert_file   : client certificate, with backend_tls_key_file
+#   backend_tls_skip_verify : don't verify servers, for test environments only
+backend_tls = false
+backend_tls_ca_file = ""
+backend_tls_server_name = ""
+backend_tls_cert_file = ""
+backend_tls_key_file = ""
+backend_tls_skip_verify = false
+
+# Set number of databases of backend redis, SELECT index of sessions should be less than it.
+# Cluster mode supports database 0 only.
+backend_databases = 16
+
+# Set the master name monitored by sentinels, required by sentinel mode.
+sentinel_master_name = ""
+
+# Set read policy of read-only commands in standalone and sentinel mode, replicas are discovered from INFO of the master.
+#   master-only    : all commands go to the master
+#   prefer-replica : read from replicas in turn, the master if none is available
+#   nearest        : read from the node with least latency, the master included
+# Replicas whose link is down or last io is older than replica_max_lag are skipped, 0 means no limit.
+read_policy = "master-only"
+replica_max_lag = "10s"
+replica_refresh_period = "10s"
+
+# Set period of reloading slots by CLUSTER SLOTS in cluster mode, 0 means reload on -MOVED only.
+cluster_refresh_period = "60s"
+
+# Set timeout & buffer size for backend connections.
+backend_dial_timeout = "5s"
+backend_recv_timeout = "30s"
+backend_send_timeout = "30s"
+backend_recv_bufsize = "128kb"
+backend_send_bufsize = "128kb"
+
+# Set TLS of client sessions, empty tls_cert_file means plaintext.
+#   tls_ca_file       : verify client certificates signed by the CA (mTLS), empty means no client certificate
+#   tls_min_version   : "1.0", "1.1", "1.2" or "1.3"
+#   tls_reload_period : period of reloading changed cert, key & CA files, 0 means never reload
+tls_cert_file = ""
+tls_key_file = ""
+tls_ca_file = ""
+tls_min_version = "1.2"
+tls_reload_period = "10s"
+
+# Set timeout & buffer size for client sessions, 0 means never timeout.
+session_recv_timeout = "30m"
+session_send_timeout = "30s"
+session_recv_bufsize = "128kb"
+session_send_bufsize = "64kb"
+
+# Set backend connection pool, connections are shared by all client sessions.
+#   max_active   : max connections per backend address
+#   max_idle     : idle connections kept after idle_timeout
+#   health_check : period of PING on idle connections, 0 means disabled
+backend_pool_max_active = 4
+backend_pool_max_idle = 1
+backend_pool_idle_timeout = "5m"
+backend_pool_health_check = "10s"
+
+# Set max number of pipelined requests, replies are flushed in batch.
+session_max_pipeline = 1024
+backend_max_pipeline = 1024
+
+# Set slow log, requests slower than slowlog_log_slower_than are kept in a ring buffer of slowlog_max_len.
+# Query by SLOWLOG GET/LEN/RESET (admin only, grant +slowlog by acl rules) or the admin api. 0 means disabled.
+# Entries are also written to log if slowlog_log is true.
+slowlog_log_slower_than = "10ms"
+slowlog_max_len = 128
+slowlog_log = false
+
+# Set access log of commands, json lines written to access_log_file.DATE, empty means disabled.
+#   access_log_rolling     : "daily" or "hourly"
+#   access_log_sample_rate : fraction of commands to log, in (0, 1]
+#   access_log_include     : commands to log, empty means all commands
+#   access_log_exclude     : commands not to log
+#   access_log_block       : wait for the writer if it falls behind instead of dropping entries
+# Arguments other than keys are never logged.
+access_log_file = ""
+access_log_rolling = "daily"
+access_log_sample_rate = 1.0
+access_log_include = []
+access_log_exclude = []
+access_log_block = false
+
+# Set max time to wait for client sessions to finish in-flight requests on shutdown, 0 means wait forever.
+shutdown_timeout = "30s"
+
+# Set acl rules of sessions without session_users and users without rules, applied in order, the last match wins.
+#   +command / -command, +@category / -@category (all, read, write, admin, dangerous), allcommands / nocommands
+#   ~pattern allows keys matching glob pattern, all keys are allowed if no pattern is given
+# INFO is aggregated by proxy and allowed by default, SLOWLOG is admin only.
+session_default_rules = "+@all -@admin -@dangerous +info"
+
+# Set proxy users, clients must AUTH [user] password before other commands, empty means no AUTH.
+# AUTH with password only is user "default". Password is hashed, run "ssawproxy --hash-password=USER:PASSWORD".
+# Rules are reloaded with users on SIGHUP.
+# [[session_users]]
+# name = "default"
+# password = "0123456789abcdef0123456789abcdef"
+# rules = "+@read -keys ~app:*"
+`
+
+type Config struct {
+	ProtoType string `toml:"proto_type" json:"proto_type"`
+	ProxyAddr string `toml:"proxy_addr" json:"proxy_addr"`
+	AdminAddr string `toml:"admin_addr" json:"admin_addr"`
+	AdminAuth string `toml:"admin_auth" json:"-"`
+
+	BackendMode  string   `toml:"backend_mode" json:"backend_mode"`
+	BackendAddrs []string `toml:"backend_addrs" json:"backend_addrs"`
+	BackendAuth  string   `toml:"backend_auth" json:"-"`
+
+	BackendTLS           bool   `toml:"backend_tls" json:"backend_tls"`
+	BackendTLSCAFile     string `toml:"backend_tls_ca_file" json:"backend_tls_ca_file"`
+	BackendTLSServerName string `toml:"backend_tls_server_name" json:"backend_tls_server_name"`
+	BackendTLSCertFile   string `toml:"backend_tls_cert_file" json:"backend_tls_cert_file"`
+	BackendTLSKeyFile    string `toml:"backend_tls_key_file" json:"backend_tls_key_file"`
+	BackendTLSSkipVerify bool   `toml:"backend_tls_skip_verify" json:"backend_tls_skip_verify"`
+
+	BackendDatabases int `toml:"backend_databases" json:"backend_databases"`
+
+	SentinelMasterName string `toml:"sentinel_master_name" json:"sentinel_master_name"`
+
+	ReadPolicy           string            `toml:"read_policy" json:"read_policy"`
+	ReplicaMaxLag        timesize.Duration `toml:"replica_max_lag" json:"replica_max_lag"`
+	ReplicaRefreshPeriod timesize.Duration `toml:"replica_refresh_period" json:"replica_refresh_period"`
+
+	ClusterRefreshPeriod timesize.Duration `toml:"cluster_refresh_period" json:"cluster_refresh_period"`
+
+	BackendDialTimeout timesize.Duration `toml:"backend_dial_timeout" json:"backend_dial_timeout"`
+	BackendRecvTimeout timesize.Duration `toml:"backend_recv_timeout" json:"backend_recv_timeout"`
+	BackendSendTimeout timesize.Duration `toml:"backend_send_timeout" json:"backend_send_timeout"`
+	BackendRecvBufsize bytesize.Int64    `toml:"backend_recv_bufsize" json:"backend_recv_bufsize"`
+	BackendSendBufsize bytesize.Int64    `toml:"backend_send_bufsize" json:"backend_send_bufsize"`
+
+	TLSCertFile     string            `toml:"tls_cert_file" json:"tls_cert_file"`
+	TLSKeyFile      string            `toml:"tls_key_file" json:"tls_key_file"`
+	TLSCAFile       string            `toml:"tls_ca_file" json:"tls_ca_file"`
+	TLSMinVersion   string            `toml:"tls_min_version" json:"tls_min_version"`
+	TLSReloadPeriod timesize.Duration `toml:"tls_reload_period" json:"tls_reload_period"`
+
+	SessionRecvTimeout timesize.Duration `toml:"session_recv_timeout" json:"session_recv_timeout"`
+	SessionSendTimeout timesize.Duration `toml:"session_send_timeout" json:"session_send_timeout"`
+	SessionRecvBufsize bytesize.Int64    `toml:"session_recv_bufsize" json:"session_recv_bufsize"`
+	SessionSendBufsize bytesize.Int64    `toml:"session_send_bufsize" json:"session_send_bufsize"`
+
+	BackendPoolMaxActive   int               `toml:"backend_pool_max_active" json:"backend_pool_max_active"`
+	BackendPoolMaxIdle     int               `toml:"backend_pool_max_idle" json:"backend_pool_max_idle"`
+	BackendPoolIdleTimeout timesize.Duration `toml:"backend_pool_idle_timeout" json:"backend_pool_idle_timeout"`
+	BackendPoolHealthCheck timesize.Duration `toml:"backend_pool_health_check" json:"backend_pool_health_check"`
+
+	SessionMaxPipeline int `toml:"session_max_pipeline" json:"session_max_pipeline"`
+	BackendMaxPipeline int `toml:"backend_max_pipeline" json:"backend_max_pipeline"`
+
+	SlowlogLogSlowerThan timesize.Duration `toml:"slowlog_log_slower_than" json:"slowlog_log_slower_than"`
+	SlowlogMaxLen        int               `toml:"slowlog_max_len" json:"slowlog_max_len"`
+	SlowlogLog           bool              `toml:"slowlog_log" json:"slowlog_log"`
+
+	AccessLogFile       string   `toml:"access_log_file" json:"access_log_file"`
+	AccessLogRolling    string   `toml:"access_log_rolling" json:"access_log_rolling"`
+	AccessLogSampleRate float64  `toml:"access_log_sample_rate" json:"access_log_sample_rate"`
+	AccessLogInclude    []string `toml:"access_log_include" json:"access_log_include"`
+	AccessLogExclude    []string `toml:"access_log_exclude" json:"access_log_exclude"`
+	AccessLogBlock      bool     `toml:"access_log_block" json:"access_log_block"`
+
+	ShutdownTimeout timesize.Duration `toml:"shutdown_timeout" json:"shutdown_timeout"`
+
+	SessionDefaultRules string        `toml:"session_default_rules" json:"session_default_rules"`
+	SessionUsers        []*UserConfig `toml:"session_users" json:"session_users"`
+}
+
+/*
+	create config from DefaultConfig
+ */
+func NewDefaultConfig() *Config {
+	c := &Config{}
+	if _, err := toml.Decode(DefaultConfig, c); err != nil {
+		log.PanicErrorf(err, "decode toml failed")
+	}
+	if err := c.Validate(); err != nil {
+		log.PanicErrorf(err, "validate config failed")
+	}
+	return c
+}
+
+/*
+	load config from toml file, fields missing in the file keep their current value
+ */
+func (c *Config) LoadFromFile(path string) error {
+	md, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) != 0 {
+		var keys []string
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return errors.Errorf("unknown config keys %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+func (c *Config) String() string {
+	// 隐藏密码
+	var copied = *c
+	if copied.BackendAuth != "" {
+		copied.BackendAuth = "******"
+	}
+	if copied.AdminAuth != "" {
+		copied.AdminAuth = "******"
+	}
+	copied.SessionUsers = make([]*UserConfig, len(c.SessionUsers))
+	for i, u := range c.SessionUsers {
+		masked := *u
+		masked.Password = "******"
+		copied.SessionUsers[i] = &masked
+	}
+	var b bytes.Buffer
+	e := toml.NewEncoder(&b)
+	e.Indent = "    "
+	e.Encode(&copied)
+	return b.String()
+}
+
+/*
+	addrList: comma separated addresses or commands for command line flags
+ */
+type addrList []string
+
+func (l *addrList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *addrList) Set(s string) error {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	*l = addrs
+	return nil
+}
+
+/*
+	register command line flags, flags parsed after LoadFromFile override the file
+ */
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ProtoType, "proto-type", c.ProtoType, "listen protocol of proxy")
+	fs.StringVar(&c.ProxyAddr, "proxy-addr", c.ProxyAddr, "listen address of proxy")
+	fs.StringVar(&c.AdminAddr, "admin-addr", c.AdminAddr, "listen address of http admin, empty means disabled")
+	fs.StringVar(&c.AdminAuth, "admin-auth", c.AdminAuth, "auth of http admin api, empty disables the api")
+	fs.StringVar(&c.BackendMode, "backend-mode", c.BackendMode, "backend mode: standalone, cluster or sentinel")
+	fs.Var((*addrList)(&c.BackendAddrs), "backend-addrs", "comma separated backend addresses")
+	fs.StringVar(&c.BackendAuth, "backend-auth", c.BackendAuth, "auth password of backend redis")
+	fs.BoolVar(&c.BackendTLS, "backend-tls", c.BackendTLS, "use TLS for backend connections")
+	fs.StringVar(&c.BackendTLSCAFile, "backend-tls-ca-file", c.BackendTLSCAFile, "CA bundle to verify backend servers")
+	fs.StringVar(&c.BackendTLSServerName, "backend-tls-server-name", c.BackendTLSServerName, "SNI server name of backend servers")
+	fs.StringVar(&c.BackendTLSCertFile, "backend-tls-cert-file", c.BackendTLSCertFile, "client certificate file of backend connections")
+	fs.StringVar(&c.BackendTLSKeyFile, "backend-tls-key-file", c.BackendTLSKeyFile, "client private key file of backend connections")
+	fs.BoolVar(&c.BackendTLSSkipVerify, "backend-tls-skip-verify", c.BackendTLSSkipVerify, "skip verifying backend servers")
+	fs.IntVar(&c.BackendDatabases, "backend-databases", c.BackendDatabases, "number of databases of backend redis")
+	fs.StringVar(&c.SentinelMasterName, "sentinel-master-name", c.SentinelMasterName, "master name monitored by sentinels")
+	fs.StringVar(&c.ReadPolicy, "read-policy", c.ReadPolicy, "read policy: master-only, prefer-replica or nearest")
+	fs.TextVar(&c.ReplicaMaxLag, "replica-max-lag", c.ReplicaMaxLag, "max replication lag of replicas to read from")
+	fs.TextVar(&c.ReplicaRefreshPeriod, "replica-refresh-period", c.ReplicaRefreshPeriod, "period of discovering replicas")
+	fs.TextVar(&c.ClusterRefreshPeriod, "cluster-refresh-period", c.ClusterRefreshPeriod, "period of reloading cluster slots")
+	fs.TextVar(&c.BackendDialTimeout, "backend-dial-timeout", c.BackendDialTimeout, "dial timeout of backend connections")
+	fs.TextVar(&c.BackendRecvTimeout, "backend-recv-timeout", c.BackendRecvTimeout, "read timeout of backend connections")
+	fs.TextVar(&c.BackendSendTimeout, "backend-send-timeout", c.BackendSendTimeout, "write timeout of backend connections")
+	fs.TextVar(&c.BackendRecvBufsize, "backend-recv-bufsize", c.BackendRecvBufsize, "read buffer size of backend connections")
+	fs.TextVar(&c.BackendSendBufsize, "backend-send-bufsize", c.BackendSendBufsize, "write buffer size of backend connections")
+	fs.StringVar(&c.TLSCertFile, "tls-cert-file", c.TLSCertFile, "certificate file of client sessions")
+	fs.StringVar(&c.TLSKeyFile, "tls-key-file", c.TLSKeyFile, "private key file of client sessions")
+	fs.StringVar(&c.TLSCAFile, "tls-ca-file", c.TLSCAFile, "CA file to verify client certificates")
+	fs.StringVar(&c.TLSMinVersion, "tls-min-version", c.TLSMinVersion, "min TLS version: 1.0, 1.1, 1.2 or 1.3")
+	fs.TextVar(&c.TLSReloadPeriod, "tls-reload-period", c.TLSReloadPeriod, "period of reloading TLS files")
+	fs.TextVar(&c.SessionRecvTimeout, "session-recv-timeout", c.SessionRecvTimeout, "read timeout of client sessions")
+	fs.TextVar(&c.SessionSendTimeout, "session-send-timeout", c.SessionSendTimeout, "write timeout of client sessions")
+	fs.TextVar(&c.SessionRecvBufsize, "session-recv-bufsize", c.SessionRecvBufsize, "read buffer size of client sessions")
+	fs.TextVar(&c.SessionSendBufsize, "session-send-bufsize", c.SessionSendBufsize, "write buffer size of client sessions")
+	fs.IntVar(&c.BackendPoolMaxActive, "backend-pool-max-active", c.BackendPoolMaxActive, "max backend connections per address")
+	fs.IntVar(&c.BackendPoolMaxIdle, "backend-pool-max-idle", c.BackendPoolMaxIdle, "idle backend connections kept per address")
+	fs.TextVar(&c.BackendPoolIdleTimeout, "backend-pool-idle-timeout", c.BackendPoolIdleTimeout, "idle time before backend connections are evicted")
+	fs.TextVar(&c.BackendPoolHealthCheck, "backend-pool-health-check", c.BackendPoolHealthCheck, "period of backend health check")
+	fs.IntVar(&c.SessionMaxPipeline, "session-max-pipeline", c.SessionMaxPipeline, "max pipelined requests of client sessions")
+	fs.IntVar(&c.BackendMaxPipeline, "backend-max-pipeline", c.BackendMaxPipeline, "max pipelined requests of backend connections")
+	fs.TextVar(&c.SlowlogLogSlowerThan, "slowlog-log-slower-than", c.SlowlogLogSlowerThan, "threshold of slow requests, 0 means disabled")
+	fs.IntVar(&c.SlowlogMaxLen, "slowlog-max-len", c.SlowlogMaxLen, "max entries of slow log")
+	fs.BoolVar(&c.SlowlogLog, "slowlog-log", c.SlowlogLog, "write slow requests to log")
+	fs.StringVar(&c.AccessLogFile, "access-log-file", c.AccessLogFile, "path of access log, empty means disabled")
+	fs.StringVar(&c.AccessLogRolling, "access-log-rolling", c.AccessLogRolling, "rolling format of access log: daily or hourly")
+	fs.Float64Var(&c.AccessLogSampleRate, "access-log-sample-rate", c.AccessLogSampleRate, "fraction of commands to write to access log")
+	fs.Var((*addrList)(&c.AccessLogInclude), "access-log-include", "comma separated commands to write to access log")
+	fs.Var((*addrList)(&c.AccessLogExclude), "access-log-exclude", "comma separated commands not to write to access log")
+	fs.BoolVar(&c.AccessLogBlock, "access-log-block", c.AccessLogBlock, "wait for access log writer instead of dropping entries")
+	fs.StringVar(&c.SessionDefaultRules, "session-default-rules", c.SessionDefaultRules, "acl rules of sessions without users")
+	fs.TextVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "max time to drain client sessions on shutdown")
+}
+
+/*
+	validate config, return the first invalid field
+ */
+func (c *Config) Validate() error {
+	switch c.ProtoType {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+	default:
+		return errors.Errorf("invalid proto_type = %q", c.ProtoType)
+	}
+	if c.ProxyAddr == "" {
+		return errors.New("invalid proxy_addr")
+	}
+	if c.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(c.AdminAddr); err != nil {
+			return errors.Errorf("invalid admin_addr = %q", c.AdminAddr)
+		}
+	}
+
+	switch c.BackendMode {
+	case BackendModeStandalone, BackendModeCluster, BackendModeSentinel:
+	default:
+		return errors.Errorf("invalid backend_mode = %q", c.BackendMode)
+	}
+	if len(c.BackendAddrs) == 0 {
+		return errors.New("invalid backend_addrs, at least one address is required")
+	}
+	if c.BackendMode == BackendModeStandalone && len(c.BackendAddrs) != 1 {
+		return errors.New("invalid backend_addrs, standalone mode requires exactly one address")
+	}
+	for _, addr := range c.BackendAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return errors.Errorf("invalid backend_addrs, bad address %q", addr)
+		}
+	}
+	if (c.BackendTLSCertFile == "") != (c.BackendTLSKeyFile == "") {
+		return errors.New("invalid backend_tls_cert_file & backend_tls_key_file, both are required")
+	}
+	if !c.BackendTLS && (c.BackendTLSCAFile != "" || c.BackendTLSCertFile != "" || c.BackendTLSServerName != "" || c.BackendTLSSkipVerify) {
+		return errors.New("invalid backend_tls, backend tls options require backend_tls = true")
+	}
+	if c.BackendDatabases <= 0 {
+		return errors.New("invalid backend_databases")
+	}
+	if c.BackendMode == BackendModeSentinel && c.SentinelMasterName == "" {
+		return errors.New("invalid sentinel_master_name, sentinel mode requires a master name")
+	}
+	switch c.ReadPolicy {
+	case ReadPolicyMasterOnly:
+	case ReadPolicyPreferReplica, ReadPolicyNearest:
+		if c.BackendMode == BackendModeCluster {
+			return errors.Errorf("invalid read_policy = %q, cluster mode supports master-only", c.ReadPolicy)
+		}
+	default:
+		return errors.Errorf("invalid read_policy = %q", c.ReadPolicy)
+	}
+	if c.ReplicaMaxLag < 0 {
+		return errors.New("invalid replica_max_lag")
+	}
+	if c.ReplicaRefreshPeriod <= 0 {
+		return errors.New("invalid replica_refresh_period")
+	}
+	if c.ClusterRefreshPeriod < 0 {
+		return errors.New("invalid cluster_refresh_period")
+	}
+
+	if c.BackendDialTimeout <= 0 {
+		return errors.New("invalid backend_dial_timeout")
+	}
+	if c.BackendRecvTimeout < 0 {
+		return errors.New("invalid backend_recv_timeout")
+	}
+	if c.BackendSendTimeout < 0 {
+		return errors.New("invalid backend_send_timeout")
+	}
+	if c.BackendRecvBufsize < 0 || c.BackendRecvBufsize > bytesize.GB {
+		return errors.New("invalid backend_recv_bufsize")
+	}
+	if c.BackendSendBufsize < 0 || c.BackendSendBufsize > bytesize.GB {
+		return errors.New("invalid backend_send_bufsize")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("invalid tls_cert_file & tls_key_file, both are required")
+	}
+	if c.TLSCAFile != "" && c.TLSCertFile == "" {
+		return errors.New("invalid tls_ca_file, tls_cert_file is required")
+	}
+	if _, ok := tlsVersions[c.TLSMinVersion]; !ok {
+		return errors.Errorf("invalid tls_min_version = %q", c.TLSMinVersion)
+	}
+	if c.TLSReloadPeriod < 0 {
+		return errors.New("invalid tls_reload_period")
+	}
+
+	if c.SessionRecvTimeout < 0 {
+		return errors.New("invalid session_recv_timeout")
+	}
+	if c.SessionSendTimeout < 0 {
+		return errors.New("invalid session_send_timeout")
+	}
+	if c.SessionRecvBufsize < 0 || c.SessionRecvBufsize > bytesize.GB {
+		return errors.New("invalid session_recv_bufsize")
+	}
+	if c.SessionSendBufsize < 0 || c.SessionSendBufsize > bytesize.GB {
+		return errors.New("invalid session_send_bufsize")
+	}
+	if c.BackendPoolMaxActive <= 0 {
+		return errors.New("invalid backend_pool_max_active")
+	}
+	if c.BackendPoolMaxIdle < 0 || c.BackendPoolMaxIdle > c.BackendPoolMaxActive {
+		return errors.New("invalid backend_pool_max_idle, should be in [0, backend_pool_max_active]")
+	}
+	if c.BackendPoolIdleTimeout < 0 {
+		return errors.New("invalid backend_pool_idle_timeout")
+	}
+	if c.BackendPoolHealthCheck < 0 {
+		return errors.New("invalid backend_pool_health_check")
+	}
+	if c.SessionMaxPipeline <= 0 {
+		return errors.New("invalid session_max_pipeline")
+	}
+	if c.BackendMaxPipeline <= 0 {
+		return errors.New("invalid backend_max_pipeline")
+	}
+	if c.SlowlogLogSlowerThan < 0 {
+		return errors.New("invalid slowlog_log_slower_than")
+	}
+	if c.SlowlogMaxLen < 0 {
+		return errors.New("invalid slowlog_max_len")
+	}
+	if _, ok := accessLogRollings[c.AccessLogRolling]; !ok {
+		return errors.Errorf("invalid access_log_rolling = %q", c.AccessLogRolling)
+	}
+	if c.AccessLogSampleRate <= 0 || c.AccessLogSampleRate > 1 {
+		return errors.New("invalid access_log_sample_rate, should be in (0, 1]")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("invalid shutdown_timeout")
+	}
+	var users = make(map[string]bool)
+	for _, u := range c.SessionUsers {
+		if u.Name == "" || strings.ContainsAny(u.Name, " \t") {
+			return errors.Errorf("invalid session_users, bad name %q", u.Name)
+		}
+		if users[u.Name] {
+			return errors.Errorf("invalid session_users, duplicate name %q", u.Name)
+		}
+		users[u.Name] = true
+		if !isValidPasswordHash(u.Password) {
+			return errors.Errorf("invalid session_users, bad password hash of %q", u.Name)
+		}
+		if _, err := parseACLRules(u.Rules); err != nil {
+			return errors.Errorf("invalid session_users, rules of %q, %s", u.Name, err)
+		}
+	}
+	if _, err := parseACLRules(c.SessionDefaultRules); err != nil {
+		return errors.Errorf("invalid session_default_rules, %s", err)
+	}
+	return nil
+}
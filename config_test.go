@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+	"SSAWPROXY/redisProxy/utils/bytesize"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	config := NewDefaultConfig()
+	assert.MustNoError(config.Validate())
+	assert.Must(config.BackendMode == BackendModeStandalone)
+	assert.Must(config.BackendDialTimeout.Get() == time.Second*5)
+	assert.Must(config.BackendRecvBufsize.Int() == 128*bytesize.KB)
+}
+
+func TestConfigLoadFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssawproxy")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxy.toml")
+	err = ioutil.WriteFile(path, []byte(`
+backend_mode = "cluster"
+backend_addrs = ["127.0.0.1:7000", "127.0.0.1:7001"]
+backend_recv_timeout = "3s"
+session_send_bufsize = "1mb"
+
+[[session_users]]
+name = "app"
+password = "`+HashPassword("app", "secret")+`"
+`), 0644)
+	assert.MustNoError(err)
+
+	config := NewDefaultConfig()
+	assert.MustNoError(config.LoadFromFile(path))
+	assert.MustNoError(config.Validate())
+	assert.Must(config.BackendMode == BackendModeCluster)
+	assert.Must(len(config.BackendAddrs) == 2)
+	assert.Must(config.BackendRecvTimeout.Get() == time.Second*3)
+	assert.Must(config.SessionSendBufsize.Int() == bytesize.MB)
+	assert.Must(config.ProxyAddr == "0.0.0.0:19000")
+	assert.Must(len(config.SessionUsers) == 1 && config.SessionUsers[0].Name == "app")
+
+	// misspelled keys are rejected
+	err = ioutil.WriteFile(path, []byte(`
+backend_addr = "127.0.0.1:6379"
+
+[[session_users]]
+name = "app"
+pasword = "secret"
+`), 0644)
+	assert.MustNoError(err)
+	err = NewDefaultConfig().LoadFromFile(path)
+	assert.Must(err != nil && strings.Contains(err.Error(), "backend_addr") && strings.Contains(err.Error(), "session_users.pasword"))
+}
+
+func TestConfigString(t *testing.T) {
+	config := NewDefaultConfig()
+	config.BackendAuth = "backend-secret"
+	config.SessionUsers = []*UserConfig{
+		{Name: "app", Password: HashPassword("app", "secret")},
+	}
+	s := config.String()
+	assert.Must(!strings.Contains(s, "backend-secret") && !strings.Contains(s, HashPassword("app", "secret")))
+	assert.Must(strings.Contains(s, "app"))
+	assert.Must(config.SessionUsers[0].Password == HashPassword("app", "secret"))
+}
+
+func TestConfigFlags(t *testing.T) {
+	config := NewDefaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.RegisterFlags(fs)
+	err := fs.Parse([]string{
+		"-backend-mode", "cluster",
+		"-backend-addrs", "127.0.0.1:7000, 127.0.0.1:7001",
+		"-backend-dial-timeout", "100ms",
+	})
+	assert.MustNoError(err)
+	assert.MustNoError(config.Validate())
+	assert.Must(len(config.BackendAddrs) == 2 && config.BackendAddrs[1] == "127.0.0.1:7001")
+	assert.Must(config.BackendDialTimeout.Get() == time.Millisecond*100)
+}
+
+func TestConfigValidate(t *testing.T) {
+	var tests = []func(c *Config){
+		func(c *Config) { c.ProxyAddr = "" },
+		func(c *Config) { c.AdminAddr = "19001" },
+		func(c *Config) { c.BackendMode = "proxy" },
+		func(c *Config) { c.BackendAddrs = nil },
+		func(c *Config) { c.BackendAddrs = []string{"127.0.0.1:6379", "127.0.0.1:6380"} },
+		func(c *Config) { c.BackendAddrs = []string{"127.0.0.1"} },
+		func(c *Config) { c.BackendMode = BackendModeSentinel },
+		func(c *Config) { c.BackendDatabases = 0 },
+		func(c *Config) { c.BackendTLSCAFile = "ca.crt" },
+		func(c *Config) { c.BackendTLS, c.BackendTLSCertFile = true, "redis.crt" },
+		func(c *Config) { c.BackendDialTimeout = 0 },
+		func(c *Config) { c.ClusterRefreshPeriod = -1 },
+		func(c *Config) { c.ReadPolicy = "random" },
+		func(c *Config) { c.BackendMode, c.ReadPolicy = BackendModeCluster, ReadPolicyNearest },
+		func(c *Config) { c.SessionRecvBufsize = -1 },
+		func(c *Config) { c.TLSCertFile = "proxy.crt" },
+		func(c *Config) { c.TLSCAFile = "ca.crt" },
+		func(c *Config) { c.TLSMinVersion = "1.4" },
+		func(c *Config) { c.SlowlogMaxLen = -1 },
+		func(c *Config) { c.AccessLogRolling = "weekly" },
+		func(c *Config) { c.AccessLogSampleRate = 0 },
+		func(c *Config) { c.SessionUsers = []*UserConfig{{Name: "app", Password: "secret"}} },
+		func(c *Config) {
+			c.SessionUsers = []*UserConfig{
+				{Name: "app", Password: HashPassword("app", "1")},
+				{Name: "app", Password: HashPassword("app", "2")},
+			}
+		},
+	}
+	for _, fn := range tests {
+		config := NewDefaultConfig()
+		fn(config)
+		assert.Must(config.Validate() != nil)
+	}
+}
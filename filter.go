@@ -1,75 +1,106 @@
 package proxy
 
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
 /*
 	1.redis command filter
 	2.error filter
+	3.blocklist: filter shared by all sessions, changed at runtime by the admin api,
+	  commands breaking the protocol of shared connections are always blocked and can't be changed
  */
 
 type filter struct {}
 
 /*
- 	command filter
+ 	command filter: commands not supported by proxy
+ 	backend connections are shared by sessions, connection state can't be kept
+ 	permissions of users (@admin, @dangerous ...) are checked by acl
   */
 func (f *filter) filter() map[string]bool{
 	filter := make(map[string]bool)
 	// keys
-	filter["KEYS"] = false
-	filter["MIGIRATE"] = false
-	filter["MOVE"] = false
-	filter["OBJECT"] = false
-	filter["DUMP"] = false
-	filter["COMMAND"] = false //导致出错
-	// lists部分
-	filter["BLPOP"] = false
-	filter["BRPOP"] = false
-	filter["BRPOPLPUSH"] = false
-	filter["RPOPLPUSH"] = false
-	// pub+sub
-	filter["PSUBSCRIBE"] = false
-	filter["PUBLISH"] = false
-	filter["PUBSUBSCRIBE"] = false
-	filter["SUBSCRIBE"] = false
-	filter["UNSUBSCRIBE"] = false
-	// transactions
-	filter["DISCARD"] = false
-	filter["EXEC"] = false
-	filter["MULTI"] = false
-	filter["UNWATCH"] = false
-	filter["WATCH"] = false
-	// scripting
-	filter["SCRIPT"] = false
-	filter["EVAL"] = false
-	filter["EVALSHA"] = false
-	// server
-	filter["BGREWRITEAOF"] = false
-	filter["BGSAVE"] = false
-	filter["CLIENT"] = false
-	filter["CONFIG"] = false
-	filter["DBSIZE"] = false
-	filter["DEBUG"] = false
-	filter["FLUSHALL"] = false
-	filter["FLUSHDB"] = false
-	filter["LASTSAVE"] = false
-	filter["LATENCY"] = false
-	filter["MONITOR"] = false
-	filter["PSYNC"] = false
-	filter["REPLCONF"] = false
-	filter["RESTORE"] = false
-	filter["SAVE"] = false
-	filter["SHUTDOWN"] = false
-	filter["SLAVEOF"] = false
-	filter["SYNC"] = false
-	filter["TIME"] = false
+	filter["MOVE"] = true
 	// slot
-	filter["SLOTSCHECK"] = false
-	filter["SLOTSDEL"] = false
-	filter["SLOTSINFO"] = false
-	filter["SLOTSMGRTONE"] = false
-	filter["SLOTSMGRTSLOT"] = false
-	filter["SLOTSMGRTTAGONE"] = false
-	filter["SLOTSMGRTTAGSLOT"] = false
+	filter["SLOTSCHECK"] = true
+	filter["SLOTSDEL"] = true
+	filter["SLOTSINFO"] = true
+	filter["SLOTSMGRTONE"] = true
+	filter["SLOTSMGRTSLOT"] = true
+	filter["SLOTSMGRTTAGONE"] = true
+	filter["SLOTSMGRTTAGSLOT"] = true
+	return filter
+}
+
+/*
+	protocol filter: commands changing the state of shared connections or streaming replies,
+	not part of the blocklist
+ */
+func (f *filter) protocol() map[string]bool {
+	filter := make(map[string]bool)
+	// connection
+	filter["CLIENT"] = true
+	filter["RESET"] = true
+	// server
+	filter["MONITOR"] = true
+	filter["PSYNC"] = true
+	filter["REPLCONF"] = true
+	filter["SYNC"] = true
 	// cluster
-	filter["READONLY"] = false
-	filter["READWRITE"] = false
+	filter["ASKING"] = true
+	filter["READONLY"] = true
+	filter["READWRITE"] = true
 	return filter
-}
\ No newline at end of file
+}
+
+type commandFilter struct {
+	mu       sync.RWMutex
+	protocol map[string]bool // fixed
+	commands map[string]bool // blocklist
+}
+
+func newCommandFilter() *commandFilter {
+	var f filter
+	return &commandFilter{protocol: f.protocol(), commands: f.filter()}
+}
+
+func (f *commandFilter) Has(opstr string) bool {
+	if f.protocol[opstr] {
+		return true
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.commands[opstr]
+}
+
+/*
+	sorted commands of the blocklist, protocol commands are not listed
+ */
+func (f *commandFilter) List() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	list := make([]string, 0, len(f.commands))
+	for opstr := range f.commands {
+		list = append(list, opstr)
+	}
+	sort.Strings(list)
+	return list
+}
+
+/*
+	replace the blocklist, applied to the next request of every session, protocol commands stay blocked
+ */
+func (f *commandFilter) Set(commands []string) {
+	m := make(map[string]bool, len(commands))
+	for _, opstr := range commands {
+		if opstr = strings.ToUpper(strings.TrimSpace(opstr)); opstr != "" {
+			m[opstr] = true
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = m
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+)
+
+/*
+	INFO [section]
+	1.proxy sections: proxy, sessions, ops, backends
+	2.other sections are sent to every master, replies are aggregated:
+	  known counters are summed (clients, memory, commands, keyspace, commandstats, errorstats),
+	  others such as uptime, ports and ratios are taken from the first master
+	3.default/all/everything include the proxy sections and backend info
+ */
+
+var proxyInfoSections = []string{"proxy", "sessions", "ops", "backends"}
+
+func infoSection(r *Request) string {
+	if len(r.Multi) > 1 {
+		return strings.ToLower(string(r.Multi[1].Value))
+	}
+	return "default"
+}
+
+func isProxyInfoSection(section string) bool {
+	for _, s := range proxyInfoSections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+func (router *Router) dispatchInfo(r *Request) error {
+	if section := infoSection(r); isProxyInfoSection(section) {
+		r.Resp = redis.NewBulkBytes(router.server.proxyInfo(section))
+		return nil
+	}
+	return router.broadcast(r)
+}
+
+/*
+	proxy sections followed by aggregated info of masters
+ */
+func (router *Router) mergeInfo(r *Request, resps []*redis.Resp) (*redis.Resp, error) {
+	for _, resp := range resps {
+		if resp.IsError() {
+			return resp, nil
+		}
+	}
+	var b bytes.Buffer
+	switch infoSection(r) {
+	case "default", "all", "everything":
+		b.Write(router.server.proxyInfo("all"))
+	}
+	b.Write(aggregateInfo(resps))
+	return redis.NewBulkBytes(b.Bytes()), nil
+}
+
+func (server *Server) proxyInfo(section string) []byte {
+	var b bytes.Buffer
+	config := server.config
+	if section == "all" || section == "proxy" {
+		fmt.Fprintf(&b, "# Proxy\r\n")
+		fmt.Fprintf(&b, "proxy_addr:%s\r\n", server.Addr())
+		fmt.Fprintf(&b, "backend_mode:%s\r\n", config.BackendMode)
+		fmt.Fprintf(&b, "process_id:%d\r\n", os.Getpid())
+		fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(server.stats.start).Seconds()))
+		fmt.Fprintf(&b, "\r\n")
+	}
+	if section == "all" || section == "sessions" {
+		fmt.Fprintf(&b, "# Sessions\r\n")
+		fmt.Fprintf(&b, "connected_sessions:%d\r\n", server.clients.Len())
+		fmt.Fprintf(&b, "total_sessions_received:%d\r\n", server.stats.sessions.Get())
+		fmt.Fprintf(&b, "\r\n")
+	}
+	if section == "all" || section == "ops" {
+		fmt.Fprintf(&b, "# Ops\r\n")
+		fmt.Fprintf(&b, "total_commands_processed:%d\r\n", server.stats.ops.Get())
+		fmt.Fprintf(&b, "total_error_replies:%d\r\n", server.stats.errors.Get())
+		fmt.Fprintf(&b, "\r\n")
+	}
+	if section == "all" || section == "backends" {
+		stats := server.manager.Stats()
+		var addrs []string
+		for addr := range stats {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		fmt.Fprintf(&b, "# Backends\r\n")
+		fmt.Fprintf(&b, "masters:%s\r\n", strings.Join(server.router.masters(), ","))
+		for i, addr := range addrs {
+			fmt.Fprintf(&b, "backend%d:addr=%s,conns=%d\r\n", i, addr, stats[addr])
+		}
+		fmt.Fprintf(&b, "\r\n")
+	}
+	return b.Bytes()
+}
+
+/*
+	merge INFO of masters section by section, key order of the first master is kept
+ */
+func aggregateInfo(resps []*redis.Resp) []byte {
+	type infoSection struct {
+		name   string
+		keys   []string
+		values map[string]string
+	}
+	var sections []*infoSection
+	var index = make(map[string]*infoSection)
+	for _, resp := range resps {
+		var current *infoSection
+		for _, line := range strings.Split(string(resp.Value), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "#") {
+				name := strings.TrimSpace(line[1:])
+				if current = index[name]; current == nil {
+					current = &infoSection{name: name, values: make(map[string]string)}
+					index[name] = current
+					sections = append(sections, current)
+				}
+				continue
+			}
+			kv := strings.SplitN(line, ":", 2)
+			if current == nil || len(kv) != 2 {
+				continue
+			}
+			if v, ok := current.values[kv[0]]; ok {
+				current.values[kv[0]] = mergeInfoValue(kv[0], v, kv[1])
+			} else {
+				current.keys = append(current.keys, kv[0])
+				current.values[kv[0]] = kv[1]
+			}
+		}
+	}
+	var b bytes.Buffer
+	for i, s := range sections {
+		if i != 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", s.name)
+		for _, key := range s.keys {
+			fmt.Fprintf(&b, "%s:%s\r\n", key, s.values[key])
+		}
+	}
+	return b.Bytes()
+}
+
+// 可累加的字段, 其余字段取第一个master的值
+var infoCounters = map[string]bool{
+	"connected_clients": true, "blocked_clients": true, "tracking_clients": true,
+	"used_memory": true, "used_memory_rss": true, "used_memory_dataset": true, "used_memory_lua": true,
+	"total_connections_received": true, "total_commands_processed": true, "instantaneous_ops_per_sec": true,
+	"total_net_input_bytes": true, "total_net_output_bytes": true, "rejected_connections": true,
+	"expired_keys": true, "evicted_keys": true, "keyspace_hits": true, "keyspace_misses": true,
+	"pubsub_channels": true, "pubsub_patterns": true, "total_error_replies": true,
+}
+
+// "k1=v1,k2=v2"格式中可累加的字段: db0:keys=1,expires=0,avg_ttl=0, cmdstat_get:calls=1,usec=2,..., errorstat_ERR:count=1
+var infoFieldCounters = map[string]bool{
+	"keys": true, "expires": true, "calls": true, "usec": true,
+	"rejected_calls": true, "failed_calls": true, "count": true,
+}
+
+/*
+	sum known counters of key, so are counter fields of "k1=v1,k2=v2" values,
+	usec_per_call of commandstats is recomputed from the summed usec & calls
+ */
+func mergeInfoValue(key, a, b string) string {
+	if infoCounters[key] {
+		return sumInfoInt(a, b)
+	}
+	fa, fb := strings.Split(a, ","), strings.Split(b, ",")
+	if len(fa) != len(fb) || !strings.Contains(a, "=") {
+		return a
+	}
+	var fields = make(map[string]string, len(fa))
+	for i := range fa {
+		ka, kb := strings.SplitN(fa[i], "=", 2), strings.SplitN(fb[i], "=", 2)
+		if len(ka) != 2 || len(kb) != 2 || ka[0] != kb[0] {
+			return a
+		}
+		if infoFieldCounters[ka[0]] {
+			ka[1] = sumInfoInt(ka[1], kb[1])
+		}
+		fields[ka[0]] = ka[1]
+		fa[i] = ka[0] + "=" + ka[1]
+	}
+	calls, err1 := strconv.ParseInt(fields["calls"], 10, 64)
+	usec, err2 := strconv.ParseInt(fields["usec"], 10, 64)
+	if err1 == nil && err2 == nil && calls != 0 {
+		for i := range fa {
+			if strings.HasPrefix(fa[i], "usec_per_call=") {
+				fa[i] = "usec_per_call=" + strconv.FormatFloat(float64(usec)/float64(calls), 'f', 2, 64)
+			}
+		}
+	}
+	return strings.Join(fa, ",")
+}
+
+func sumInfoInt(a, b string) string {
+	x, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return a
+	}
+	y, err := strconv.ParseInt(b, 10, 64)
+	if err != nil {
+		return a
+	}
+	return strconv.FormatInt(x+y, 10)
+}
@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	sysutils "SSAWPROXY/redisProxy/utils"
+	"SSAWPROXY/redisProxy/utils/sync2/atomic2"
+)
+
+/*
+	metrics of proxy traffic, exported by GET /metrics of the admin listener in prometheus text format
+	1.ops & latency histogram per command, commands out of the command table are "unknown"
+	2.error replies by type, the prefix of the error such as ERR, WRONGTYPE, NOAUTH
+	3.sessions, backend pool sizes and followed -MOVED/-ASK redirects
+	4.process cpu & rss, see utils.GetUsage & utils.CPUUsage
+	5.dropped entries of access log
+ */
+
+// 延迟分桶, 单位秒
+var latencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+type serverStats struct {
+	start    time.Time
+	ops      atomic2.Int64 // replies of commands
+	errors   atomic2.Int64 // error replies
+	sessions atomic2.Int64 // accepted client sessions
+
+	moved atomic2.Int64
+	ask   atomic2.Int64
+
+	mu         sync.RWMutex
+	commands   map[string]*commandStats
+	errorTypes map[string]*atomic2.Int64
+}
+
+type commandStats struct {
+	calls   atomic2.Int64
+	errors  atomic2.Int64
+	nanos   atomic2.Int64
+	buckets []atomic2.Int64 // 非累计, 最后一个为+Inf
+}
+
+/*
+	record the reply of a request
+ */
+func (s *serverStats) incr(r *Request, resp *redis.Resp) {
+	s.ops.Incr()
+	c := s.command(r.OpStr)
+	c.calls.Incr()
+	d := time.Since(r.Start)
+	c.nanos.Add(int64(d))
+	c.buckets[sort.SearchFloat64s(latencyBuckets, d.Seconds())].Incr()
+	if resp != nil && resp.IsError() {
+		s.errors.Incr()
+		c.errors.Incr()
+		s.errorType(resp).Incr()
+	}
+}
+
+func (s *serverStats) redirect(ask bool) {
+	if ask {
+		s.ask.Incr()
+	} else {
+		s.moved.Incr()
+	}
+}
+
+func (s *serverStats) command(opstr string) *commandStats {
+	if commandTable[opstr] == nil {
+		opstr = "UNKNOWN"
+	}
+	s.mu.RLock()
+	c := s.commands[opstr]
+	s.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c = s.commands[opstr]; c == nil {
+		if s.commands == nil {
+			s.commands = make(map[string]*commandStats)
+		}
+		c = &commandStats{buckets: make([]atomic2.Int64, len(latencyBuckets)+1)}
+		s.commands[opstr] = c
+	}
+	return c
+}
+
+/*
+	counter of the error prefix, prefixes that are not an upper case word are "UNKNOWN"
+ */
+func (s *serverStats) errorType(resp *redis.Resp) *atomic2.Int64 {
+	name := string(resp.Value)
+	if i := strings.IndexByte(name, ' '); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" || len(name) > 32 || strings.TrimLeft(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		name = "UNKNOWN"
+	}
+	s.mu.RLock()
+	n := s.errorTypes[name]
+	s.mu.RUnlock()
+	if n != nil {
+		return n
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n = s.errorTypes[name]; n == nil {
+		if s.errorTypes == nil {
+			s.errorTypes = make(map[string]*atomic2.Int64)
+		}
+		n = &atomic2.Int64{}
+		s.errorTypes[name] = n
+	}
+	return n
+}
+
+/*
+	prometheus text format writer
+ */
+type metricsWriter struct {
+	b bytes.Buffer
+}
+
+func (w *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+/*
+	labels are pairs of name & value
+ */
+func (w *metricsWriter) value(name string, v interface{}, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) != 0 {
+		w.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i != 0 {
+				w.b.WriteByte(',')
+			}
+			fmt.Fprintf(&w.b, "%s=%q", labels[i], labels[i+1])
+		}
+		w.b.WriteByte('}')
+	}
+	fmt.Fprintf(&w.b, " %v\n", v)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*commandStats:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*atomic2.Int64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]int:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+	write all metrics of the server, cpu is the latest usage sampled by the admin listener
+ */
+func (server *Server) writeMetrics(out io.Writer, cpu float64) error {
+	var w metricsWriter
+	stats := &server.stats
+
+	w.header("ssawproxy_uptime_seconds", "gauge", "Seconds since the proxy started.")
+	w.value("ssawproxy_uptime_seconds", int64(time.Since(stats.start).Seconds()))
+
+	w.header("ssawproxy_sessions", "gauge", "Alive client sessions.")
+	w.value("ssawproxy_sessions", server.clients.Len())
+	w.header("ssawproxy_sessions_total", "counter", "Accepted client sessions.")
+	w.value("ssawproxy_sessions_total", stats.sessions.Get())
+
+	stats.mu.RLock()
+	commands := make(map[string]*commandStats, len(stats.commands))
+	for k, v := range stats.commands {
+		commands[k] = v
+	}
+	errorTypes := make(map[string]*atomic2.Int64, len(stats.errorTypes))
+	for k, v := range stats.errorTypes {
+		errorTypes[k] = v
+	}
+	stats.mu.RUnlock()
+
+	w.header("ssawproxy_commands_total", "counter", "Replied commands.")
+	for _, name := range sortedKeys(commands) {
+		w.value("ssawproxy_commands_total", commands[name].calls.Get(), "cmd", strings.ToLower(name))
+	}
+	w.header("ssawproxy_command_errors_total", "counter", "Error replies of commands.")
+	for _, name := range sortedKeys(commands) {
+		w.value("ssawproxy_command_errors_total", commands[name].errors.Get(), "cmd", strings.ToLower(name))
+	}
+	w.header("ssawproxy_command_duration_seconds", "histogram", "Latency from reading a command to its reply.")
+	for _, name := range sortedKeys(commands) {
+		c, cmd := commands[name], strings.ToLower(name)
+		var count int64
+		for i := range c.buckets {
+			count += c.buckets[i].Get()
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = fmt.Sprint(latencyBuckets[i])
+			}
+			w.value("ssawproxy_command_duration_seconds_bucket", count, "cmd", cmd, "le", le)
+		}
+		w.value("ssawproxy_command_duration_seconds_sum", time.Duration(c.nanos.Get()).Seconds(), "cmd", cmd)
+		w.value("ssawproxy_command_duration_seconds_count", count, "cmd", cmd)
+	}
+
+	w.header("ssawproxy_errors_total", "counter", "Error replies by type.")
+	for _, name := range sortedKeys(errorTypes) {
+		w.value("ssawproxy_errors_total", errorTypes[name].Get(), "type", name)
+	}
+
+	w.header("ssawproxy_redirects_total", "counter", "Followed cluster redirects.")
+	w.value("ssawproxy_redirects_total", stats.moved.Get(), "type", "moved")
+	w.value("ssawproxy_redirects_total", stats.ask.Get(), "type", "ask")
+
+	pools := server.manager.Stats()
+	w.header("ssawproxy_backend_pool_conns", "gauge", "Pooled connections of backend addresses.")
+	for _, addr := range sortedKeys(pools) {
+		w.value("ssawproxy_backend_pool_conns", pools[addr], "addr", addr)
+	}
+
+	if server.accessLog != nil {
+		w.header("ssawproxy_access_log_dropped_total", "counter", "Access log entries dropped as the writer falls behind.")
+		w.value("ssawproxy_access_log_dropped_total", server.accessLog.dropped.Get())
+	}
+
+	if u, err := sysutils.GetUsage(); err == nil {
+		w.header("process_cpu_seconds_total", "counter", "Total user and system CPU time spent in seconds.")
+		w.value("process_cpu_seconds_total", u.CPUTotal().Seconds())
+		w.header("process_resident_memory_bytes", "gauge", "Resident memory size in bytes.")
+		w.value("process_resident_memory_bytes", u.MemTotal())
+	}
+	w.header("process_cpu_usage", "gauge", "CPU usage of the latest sample period, 1 means a core.")
+	w.value("process_cpu_usage", cpu)
+
+	_, err := out.Write(w.b.Bytes())
+	return err
+}
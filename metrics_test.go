@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.AdminAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+	_, err = c.Write([]byte("SET k v\r\nGET 3\r\nGET\r\nFOO\r\n"))
+	assert.MustNoError(err)
+	for _, expect := range []string{"+OK\r\n", "$3\r\n", "xxx\r\n", "-ERR wrong number", "+OK\r\n"} {
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		assert.Must(strings.HasPrefix(line, expect))
+	}
+
+	rsp, err := http.Get("http://" + server.AdminAddr() + "/metrics")
+	assert.MustNoError(err)
+	defer rsp.Body.Close()
+	assert.Must(rsp.StatusCode == http.StatusOK)
+	b, err := ioutil.ReadAll(rsp.Body)
+	assert.MustNoError(err)
+
+	metrics := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.LastIndexByte(line, ' '); i > 0 && !strings.HasPrefix(line, "#") {
+			metrics[line[:i]] = line[i+1:]
+		}
+	}
+	assert.Must(metrics["ssawproxy_sessions"] == "1")
+	assert.Must(metrics["ssawproxy_sessions_total"] == "1")
+	assert.Must(metrics[`ssawproxy_commands_total{cmd="set"}`] == "1")
+	assert.Must(metrics[`ssawproxy_commands_total{cmd="get"}`] == "2")
+	assert.Must(metrics[`ssawproxy_commands_total{cmd="unknown"}`] == "1")
+	assert.Must(metrics[`ssawproxy_command_errors_total{cmd="get"}`] == "1")
+	assert.Must(metrics[`ssawproxy_command_duration_seconds_bucket{cmd="get",le="+Inf"}`] == "2")
+	assert.Must(metrics[`ssawproxy_command_duration_seconds_count{cmd="get"}`] == "2")
+	assert.Must(metrics[`ssawproxy_errors_total{type="ERR"}`] == "1")
+	assert.Must(metrics[`ssawproxy_redirects_total{type="moved"}`] == "0")
+	pool := metrics[`ssawproxy_backend_pool_conns{addr="`+backend.Addr().String()+`"}`]
+	assert.Must(pool != "" && pool != "0")
+	assert.Must(metrics["process_resident_memory_bytes"] != "")
+	assert.Must(metrics["process_cpu_usage"] != "")
+}
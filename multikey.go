@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"strconv"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/sync2"
+)
+
+/*
+	multi-key commands in cluster mode
+	1.split: keys are grouped by slot, one sub request per slot
+	2.sub requests are dispatched in parallel, each follows -MOVED/-ASK by itself
+	3.coalesce: MGET replies in original key order, MSET +OK, others summed integer
+	  SCRIPT & INFO broadcast to masters are merged by mergeScript & mergeInfo
+ */
+
+// key step of multi-key commands, MSET key value [key value ...]
+var multiKeyCommands = map[string]int{
+	"MGET":   1,
+	"MSET":   2,
+	"DEL":    1,
+	"EXISTS": 1,
+	"UNLINK": 1,
+	"TOUCH":  1,
+}
+
+/*
+	split request by slot of keys, nil if all keys are in the same slot
+ */
+func (router *Router) split(r *Request) []*Request {
+	if router.server.config.BackendMode != BackendModeCluster {
+		return nil
+	}
+	step, ok := multiKeyCommands[r.OpStr]
+	if !ok || len(r.Multi) <= 1+step || (len(r.Multi)-1)%step != 0 {
+		// 参数个数错误由redis返回
+		return nil
+	}
+	var subs []*Request
+	var groups = make(map[int]*Request)
+	for i := 1; i < len(r.Multi); i += step {
+		slot := redis.HashSlot(r.Multi[i].Value)
+		sub := groups[slot]
+		if sub == nil {
+			sub = NewRequest([]*redis.Resp{r.Multi[0]}, r.OpStr)
+			sub.Start = r.Start
+			sub.Database = r.Database
+			groups[slot] = sub
+			subs = append(subs, sub)
+		}
+		sub.Multi = append(sub.Multi, r.Multi[i:i+step]...)
+		sub.Index = append(sub.Index, (i-1)/step)
+	}
+	if len(subs) == 1 {
+		return nil
+	}
+	return subs
+}
+
+/*
+	wait for all sub requests and merge their replies
+ */
+func (router *Router) coalesce(r *Request) (*redis.Resp, error) {
+	var future sync2.Future
+	for i, sub := range r.Subs {
+		future.Add()
+		go func(key string, sub *Request) {
+			resp, err := router.redirect(sub)
+			if err != nil {
+				future.Done(key, err)
+			} else {
+				future.Done(key, resp)
+			}
+		}(strconv.Itoa(i), sub)
+	}
+	results := future.Wait()
+
+	var resps = make([]*redis.Resp, len(r.Subs))
+	for i := range r.Subs {
+		switch v := results[strconv.Itoa(i)].(type) {
+		case error:
+			return nil, v
+		case *redis.Resp:
+			resps[i] = v
+		}
+	}
+	switch r.OpStr {
+	case "SCRIPT":
+		return mergeScript(r, resps)
+	case "INFO":
+		return router.mergeInfo(r, resps)
+	}
+	for _, resp := range resps {
+		if resp.IsError() {
+			return resp, nil
+		}
+	}
+
+	switch r.OpStr {
+	case "MGET":
+		var array = make([]*redis.Resp, len(r.Multi)-1)
+		for i, sub := range r.Subs {
+			if !resps[i].IsArray() || len(resps[i].Array) != len(sub.Index) {
+				return nil, errors.Errorf("bad mget reply, type %s", resps[i].Type)
+			}
+			for j, index := range sub.Index {
+				array[index] = resps[i].Array[j]
+			}
+		}
+		return redis.NewArray(array), nil
+	case "MSET":
+		return redis.NewString([]byte("OK")), nil
+	default:
+		var sum int64
+		for _, resp := range resps {
+			if !resp.IsInt() {
+				return nil, errors.Errorf("bad %s reply, type %s", r.OpStr, resp.Type)
+			}
+			n, err := redis.Btoi64(resp.Value)
+			if err != nil {
+				return nil, err
+			}
+			sum += n
+		}
+		return redis.NewInt(strconv.AppendInt(nil, sum, 10)), nil
+	}
+}
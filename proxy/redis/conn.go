@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -26,17 +27,43 @@ type Conn struct {
 }
 
 func DialTimeout(addr string, timeout time.Duration, rbuf, wbuf int) (*Conn, error) {
-	c, err := net.DialTimeout("tcp", addr, timeout)
+	return DialTLSTimeout(addr, timeout, rbuf, wbuf, nil)
+}
+
+/*
+	dial with TLS if config is not nil
+ */
+func DialTLSTimeout(addr string, timeout time.Duration, rbuf, wbuf int, config *tls.Config) (*Conn, error) {
+	c, err := DialSock(addr, timeout, config)
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, err
 	}
 	return NewConn(c, rbuf, wbuf), nil
 }
 
+/*
+	dial a tcp connection, wrapped in TLS if config is not nil,
+	server name is the host of addr if config doesn't set it
+ */
+func DialSock(addr string, timeout time.Duration, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		c, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return c, nil
+	}
+	c, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c, nil
+}
+
 func NewConn(sock net.Conn, rbuf, wbuf int) *Conn {
 	conn := &Conn{Sock: sock}
 	conn.Decoder = newConnDecoder(conn, rbuf)
-	conn.Decoder = newConnEncoder(conn, wbuf)
+	conn.Encoder = newConnEncoder(conn, wbuf)
 	return conn
 }
 
@@ -53,7 +80,12 @@ func (conn *Conn) Close() error {
 }
 
 func (conn *Conn) CloseReader() error {
-	if t, ok := conn.Sock.(*net.TCPConn); ok{
+	sock := conn.Sock
+	if t, ok := sock.(*tls.Conn); ok {
+		// 关闭tls底层tcp的读端, 仍可写入回复
+		sock = t.NetConn()
+	}
+	if t, ok := sock.(*net.TCPConn); ok{
 		return t.CloseRead()
 	}
 	return conn.Close()
@@ -193,7 +225,7 @@ func (p *FlushEncoder) Encode(resp *Resp) error {
 }
 
 func (p *FlushEncoder) EncodeMultiBulk(multi []*Resp) error {
-	if err := p.Conn.encodeMultiBulk(multi, false); err != nil {
+	if err := p.Conn.EncodeMultiBulk(multi, false); err != nil {
 		return err
 	} else {
 		p.nbuffered++
@@ -7,8 +7,8 @@ import (
 	"SSAWPROXY/redisProxy/utils/assert"
 )
 
-func TestNewConn(t *testing.T) {
-	l, err := net.Listen("tcp", "10.101.20.69:9999")
+func newConnPair() (*Conn, *Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
 	assert.MustNoError(err)
 	defer l.Close()
 
@@ -31,3 +31,21 @@ func TestNewConn(t *testing.T) {
 	assert.Must(ok)
 	return conn1, conn2
 }
+
+func TestNewConn(t *testing.T) {
+	conn1, conn2 := newConnPair()
+	defer conn1.Close()
+	defer conn2.Close()
+
+	multi := []*Resp{NewBulkBytes([]byte("GET")), NewBulkBytes([]byte("key"))}
+	assert.MustNoError(conn1.EncodeMultiBulk(multi, true))
+
+	req, err := conn2.DecodeMultiBulk()
+	assert.MustNoError(err)
+	assert.Must(len(req) == 2 && string(req[1].Value) == "key")
+
+	assert.MustNoError(conn2.Encode(NewBulkBytes([]byte("value")), true))
+	resp, err := conn1.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsBulkBytes() && string(resp.Value) == "value")
+}
@@ -3,11 +3,28 @@ package redis
 
 import (
 	"bytes"
+	"math"
+	"strconv"
 	"testing"
 
 	"SSAWPROXY/redisProxy/utils/assert"
 )
 
+var tmap = make(map[int64][]byte)
+
+func init() {
+	var n = len(itoaOffset)*2 + 100000
+	for i := -n; i <= n; i++ {
+		tmap[int64(i)] = []byte(strconv.Itoa(int(i)))
+	}
+	for i := math.MinInt64; i != 0; i = int(float64(i) / 1.1) {
+		tmap[int64(i)] = []byte(strconv.Itoa(int(i)))
+	}
+	for i := math.MaxInt64; i != 0; i = int(float64(i) / 1.1) {
+		tmap[int64(i)] = []byte(strconv.Itoa(int(i)))
+	}
+}
+
 func TestBtoi64(t *testing.T) {
 	for i, b := range tmap {
 		v, err := Btoi64(b)
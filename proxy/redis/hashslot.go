@@ -0,0 +1,59 @@
+package redis
+
+/*
+	hashslot.go: redis cluster key slot
+	slot = CRC16(key) mod 16384, only the {hash tag} is hashed if present
+*/
+
+const MaxSlotNum = 16384
+
+var crc16tab [256]uint16
+
+func init() {
+	// CRC16-CCITT (XMODEM), polynomial 0x1021
+	for i := range crc16tab {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc = crc << 1
+			}
+		}
+		crc16tab[i] = crc
+	}
+}
+
+func Crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^c]
+	}
+	return crc
+}
+
+/*
+	return the part of key between the first '{' and the following '}',
+	the whole key if there is no such non-empty tag
+*/
+func HashTag(key []byte) []byte {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j == i+1 {
+					return key
+				}
+				return key[i+1 : j]
+			}
+		}
+		return key
+	}
+	return key
+}
+
+func HashSlot(key []byte) int {
+	return int(Crc16(HashTag(key)) % MaxSlotNum)
+}
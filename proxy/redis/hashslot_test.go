@@ -0,0 +1,30 @@
+package redis
+
+import (
+	"testing"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+func TestCrc16(t *testing.T) {
+	assert.Must(Crc16([]byte("123456789")) == 0x31C3)
+	assert.Must(Crc16(nil) == 0)
+}
+
+func TestHashSlot(t *testing.T) {
+	assert.Must(HashSlot([]byte("foo")) == 12182)
+	assert.Must(HashSlot([]byte("bar")) == 5061)
+	assert.Must(HashSlot([]byte("{user1000}.following")) == HashSlot([]byte("{user1000}.followers")))
+	assert.Must(HashSlot([]byte("{user1000}.following")) == HashSlot([]byte("user1000")))
+	var tests = map[string]string{
+		"foo{}{bar}":    "foo{}{bar}",
+		"foo{{bar}}zap": "{bar",
+		"foo{bar}{zap}": "bar",
+		"{foo":          "{foo",
+		"foo}{":         "foo}{",
+		"{}":            "{}",
+	}
+	for key, tag := range tests {
+		assert.Must(string(HashTag([]byte(key))) == tag)
+	}
+}
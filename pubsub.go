@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+)
+
+/*
+	pub/sub of client sessions
+	1.SUBSCRIBE/PSUBSCRIBE switch the session into subscriber mode,
+	  a dedicated backend connection is dialed for the session
+	2.SSUBSCRIBE is routed by slot of the channel, one connection per node
+	3.replies and messages of subscriptions are pushed to loopWriter in arrival order
+	4.subscription commands are sent by loopWriter followed by PING <sync>,
+	  loopWriter writes pushed replies until the sync reply, so replies keep the request order
+	5.in subscriber mode only (P|S)SUBSCRIBE, (P|S)UNSUBSCRIBE, PING and QUIT are allowed,
+	  subscriptions are counted by loopReader per command as redis does, so pipelined commands
+	  after UNSUBSCRIBE are checked against the mode they are sent in
+	6.the session is closed if a subscription connection is lost
+	PUBLISH is an ordinary command, SPUBLISH is routed by slot of the channel
+ */
+
+var (
+	ErrClosedPubSub = errors.New("use of closed pubsub")
+	ErrLostPubSub   = errors.New("subscription connection lost")
+)
+
+var pubsubCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"SSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"SUNSUBSCRIBE": true,
+}
+
+var (
+	pubsubSyncToken = []byte("ssawproxy-pubsub-sync")
+	pubsubSync      = &redis.Resp{}
+)
+
+type pubsub struct {
+	mu     sync.Mutex
+	client *Client
+
+	conns   map[string]*pubsubConn
+	regular string	// address of connection for SUBSCRIBE/PSUBSCRIBE
+
+	lost struct {
+		C    chan struct{}
+		once sync.Once
+	}
+	exit struct {
+		C chan struct{}
+	}
+	closed bool
+}
+
+type pubsubConn struct {
+	*redisConn
+}
+
+/*
+	channels, patterns & shard channels of a session, used by loopReader
+ */
+type subscriptions struct {
+	channels map[string]bool
+	patterns map[string]bool
+	shards   map[string]bool
+}
+
+/*
+	apply a subscription command, UNSUBSCRIBE without arguments removes all of its kind
+ */
+func (s *subscriptions) update(r *Request) {
+	var m *map[string]bool
+	var add bool
+	switch r.OpStr {
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		m, add = &s.channels, r.OpStr == "SUBSCRIBE"
+	case "PSUBSCRIBE", "PUNSUBSCRIBE":
+		m, add = &s.patterns, r.OpStr == "PSUBSCRIBE"
+	case "SSUBSCRIBE", "SUNSUBSCRIBE":
+		m, add = &s.shards, r.OpStr == "SSUBSCRIBE"
+	default:
+		return
+	}
+	if *m == nil {
+		*m = make(map[string]bool)
+	}
+	if !add && len(r.Multi) == 1 {
+		*m = nil
+		return
+	}
+	for _, name := range r.Multi[1:] {
+		if add {
+			(*m)[string(name.Value)] = true
+		} else {
+			delete(*m, string(name.Value))
+		}
+	}
+}
+
+func (s *subscriptions) count() int {
+	return len(s.channels) + len(s.patterns) + len(s.shards)
+}
+
+func newPubSub(client *Client) *pubsub {
+	ps := &pubsub{client: client, conns: make(map[string]*pubsubConn)}
+	ps.lost.C = make(chan struct{})
+	ps.exit.C = make(chan struct{})
+	return ps
+}
+
+/*
+	send a subscription command and write its replies, called by loopWriter in order
+ */
+func (ps *pubsub) handle(r *Request, p *redis.FlushEncoder) error {
+	var addrs []string
+	var create bool
+	router := ps.client.server.router
+	switch r.OpStr {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if ps.regular == "" {
+			ps.regular = router.masterAddr()
+		}
+		addrs, create = []string{ps.regular}, true
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		addrs = []string{ps.regular}
+	case "SSUBSCRIBE":
+		addrs, create = []string{router.lookupChannel(r.Multi[1].Value)}, true
+	case "SUNSUBSCRIBE":
+		if len(r.Multi) > 1 {
+			addrs = []string{router.lookupChannel(r.Multi[1].Value)}
+		} else {
+			for _, addr := range ps.addrs() {
+				if addr != ps.regular {
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+	default:
+		// PING
+		if ps.conn(ps.regular) != nil {
+			addrs = []string{ps.regular}
+		} else if all := ps.addrs(); len(all) != 0 {
+			addrs = all[:1]
+		}
+	}
+
+	var synced int
+	for _, addr := range addrs {
+		c, err := ps.getConn(addr, create)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			continue
+		}
+		if err := c.Send(r.Multi, false); err != nil {
+			return err
+		}
+		ping := []*redis.Resp{redis.NewBulkBytes([]byte("PING")), redis.NewBulkBytes(pubsubSyncToken)}
+		if err := c.Send(ping, true); err != nil {
+			return err
+		}
+		synced++
+	}
+	if synced == 0 {
+		for _, resp := range ps.reply(r) {
+			if err := p.Encode(resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// 写入推送的回复, 直到每个连接的sync回复
+	for synced != 0 {
+		select {
+		case resp := <-ps.client.push:
+			if resp == pubsubSync {
+				synced--
+			} else if err := p.Encode(resp); err != nil {
+				return err
+			}
+		case <-ps.lost.C:
+			return ErrLostPubSub
+		}
+	}
+	return nil
+}
+
+func (ps *pubsub) conn(addr string) *pubsubConn {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.conns[addr]
+}
+
+func (ps *pubsub) addrs() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var addrs []string
+	for addr := range ps.conns {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+/*
+	connection of addr, dialed if create is true
+ */
+func (ps *pubsub) getConn(addr string, create bool) (*pubsubConn, error) {
+	if c := ps.conn(addr); c != nil || !create {
+		return c, nil
+	}
+	if addr == "" {
+		return nil, ErrNoMaster
+	}
+	rConn, err := ps.client.server.manager.connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	// 订阅连接没有读超时
+	rConn.rc.ReaderTimeout = 0
+	c := &pubsubConn{redisConn: rConn}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		rConn.Close()
+		return nil, ErrClosedPubSub
+	}
+	ps.conns[addr] = c
+	go ps.loopReader(addr, c)
+	return c, nil
+}
+
+/*
+	reply of unsubscribe or PING without any subscription connection
+ */
+func (ps *pubsub) reply(r *Request) []*redis.Resp {
+	if r.OpStr == "PING" {
+		var message []byte
+		if len(r.Multi) > 1 {
+			message = r.Multi[1].Value
+		}
+		return []*redis.Resp{redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes([]byte("pong")), redis.NewBulkBytes(message),
+		})}
+	}
+	var kind = []byte(strings.ToLower(r.OpStr))
+	if len(r.Multi) == 1 {
+		return []*redis.Resp{redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes(kind), redis.NewBulkBytes(nil), redis.NewInt([]byte("0")),
+		})}
+	}
+	var resps []*redis.Resp
+	for _, channel := range r.Multi[1:] {
+		resps = append(resps, redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes(kind), redis.NewBulkBytes(channel.Value), redis.NewInt([]byte("0")),
+		}))
+	}
+	return resps
+}
+
+func (ps *pubsub) loopReader(addr string, c *pubsubConn) {
+	for {
+		resp, err := c.Receive()
+		if err != nil {
+			ps.mu.Lock()
+			closed := ps.closed
+			ps.mu.Unlock()
+			if !closed {
+				log.WarnErrorf(err, "client [%s] subscription to %s lost", ps.client.RemoteAddr(), addr)
+				ps.lost.once.Do(func() {
+					close(ps.lost.C)
+				})
+				ps.client.Close()
+			}
+			return
+		}
+		if isPubSubSync(resp) {
+			resp = pubsubSync
+		}
+		if !ps.client.pushResp(resp, ps.exit.C) {
+			return
+		}
+	}
+}
+
+/*
+	reply of PING <sync>: bulk in normal mode, [pong, sync] in subscriber mode
+ */
+func isPubSubSync(resp *redis.Resp) bool {
+	if resp.IsBulkBytes() {
+		return bytes.Equal(resp.Value, pubsubSyncToken)
+	}
+	if resp.IsArray() && len(resp.Array) == 2 {
+		return bytes.Equal(resp.Array[0].Value, []byte("pong")) && bytes.Equal(resp.Array[1].Value, pubsubSyncToken)
+	}
+	return false
+}
+
+func (ps *pubsub) Close() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return
+	}
+	ps.closed = true
+	close(ps.exit.C)
+	for _, c := range ps.conns {
+		c.Close()
+	}
+}
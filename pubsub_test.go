@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	fake redis with pub/sub for tests:
+	SUBSCRIBE/UNSUBSCRIBE channels, PUBLISH channel message -> number of receivers,
+	PING [message] -> +PONG, bulk message or pong array in subscriber mode, others -> +OK
+ */
+func newFakePubSub() net.Listener {
+	var mu sync.Mutex
+	var subs = make(map[string]map[net.Conn]bool)
+	return newFakeServer(func() fakeHandler {
+		var channels = make(map[string]bool)
+		return func(c net.Conn, multi []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			var b string
+			switch strings.ToUpper(multi[0]) {
+			case "SUBSCRIBE":
+				for _, ch := range multi[1:] {
+					channels[ch] = true
+					if subs[ch] == nil {
+						subs[ch] = make(map[net.Conn]bool)
+					}
+					subs[ch][c] = true
+					b += "*3\r\n" + fakeBulk("subscribe") + fakeBulk(ch) + ":" + strconv.Itoa(len(channels)) + "\r\n"
+				}
+			case "UNSUBSCRIBE":
+				unsubscribe := multi[1:]
+				if len(unsubscribe) == 0 {
+					for ch := range channels {
+						unsubscribe = append(unsubscribe, ch)
+					}
+				}
+				for _, ch := range unsubscribe {
+					delete(channels, ch)
+					delete(subs[ch], c)
+					b += "*3\r\n" + fakeBulk("unsubscribe") + fakeBulk(ch) + ":" + strconv.Itoa(len(channels)) + "\r\n"
+				}
+			case "PUBLISH":
+				for sub := range subs[multi[1]] {
+					sub.Write([]byte("*3\r\n" + fakeBulk("message") + fakeBulk(multi[1]) + fakeBulk(multi[2])))
+				}
+				b = ":" + strconv.Itoa(len(subs[multi[1]])) + "\r\n"
+			case "PING":
+				var message string
+				if len(multi) > 1 {
+					message = multi[1]
+				}
+				switch {
+				case len(channels) != 0:
+					b = "*2\r\n" + fakeBulk("pong") + fakeBulk(message)
+				case len(multi) > 1:
+					b = fakeBulk(message)
+				default:
+					b = "+PONG\r\n"
+				}
+			default:
+				b = "+OK\r\n"
+			}
+			return b
+		}
+	})
+}
+
+func TestClientPubSub(t *testing.T) {
+	backend := newFakePubSub()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	sub := dialTestConn(server.Addr())
+	defer sub.Close()
+	pub := dialTestConn(server.Addr())
+	defer pub.Close()
+
+	_, err := sub.Write([]byte("*1\r\n$4\r\nPING\r\n*2\r\n$9\r\nSUBSCRIBE\r\n$3\r\nfoo\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n*1\r\n$4\r\nPING\r\n"))
+	assert.MustNoError(err)
+	expectString(sub.decode(), "PONG")
+	expectArray(sub.decode(), "subscribe", "foo", "1")
+	resp := sub.decode()
+	assert.Must(resp.IsError() && strings.HasPrefix(string(resp.Value), "ERR Can't execute 'get'"))
+	expectArray(sub.decode(), "pong", "")
+
+	expectString(pub.do("PUBLISH", "foo", "hello"), "1")
+	expectArray(sub.decode(), "message", "foo", "hello")
+
+	// subscriber mode is left by UNSUBSCRIBE itself, pipelined commands are not rejected
+	_, err = sub.Write([]byte("*2\r\n$11\r\nUNSUBSCRIBE\r\n$3\r\nfoo\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*1\r\n$4\r\nPING\r\n"))
+	assert.MustNoError(err)
+	expectArray(sub.decode(), "unsubscribe", "foo", "0")
+	expectString(sub.decode(), "OK")
+	expectString(sub.decode(), "PONG")
+
+	// UNSUBSCRIBE without channels removes all of them
+	_, err = sub.Write([]byte("*2\r\n$9\r\nSUBSCRIBE\r\n$3\r\nbar\r\n*1\r\n$11\r\nUNSUBSCRIBE\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"))
+	assert.MustNoError(err)
+	expectArray(sub.decode(), "subscribe", "bar", "1")
+	expectArray(sub.decode(), "unsubscribe", "bar", "0")
+	expectString(sub.decode(), "OK")
+}
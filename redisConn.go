@@ -1,38 +1,46 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"net"
-	"bufio"
+	"strconv"
 	"sync"
 	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
 	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/sync2/atomic2"
 )
 
 type redisConn struct {
 	conn		net.Conn
 	server		*Server
 	err 		error
-	pending		int
+	pending		atomic2.Int64	// requests sent but not replied
+	lastUse		atomic2.Int64	// unix nano of last request
 	mu		sync.Mutex
 
-	br		*bufio.Reader
-	readTimeout	time.Duration
+	addr		string
+	database	int
+
+	rc		*redis.Conn	// decode replies from & encode requests to redis
 
-	bw		*bufio.Writer
-	writeTimeout	time.Duration
+	input		chan *Request	// requests waiting to be sent, see run()
+	inputMu		sync.Mutex	// guards input, never held by loopWriter/loopReader
 
 	password	string
-	LastWrite	time.Time
 }
 
+const (
+	backendFlushMaxInterval = time.Microsecond * 300
+	backendFlushMaxBuffered = 64
+)
+
 func NewConn(netConn net.Conn, readTimeout, writeTimeout time.Duration) *redisConn {
-	return &redisConn{
-		conn:         netConn,
-		bw:           bufio.NewWriter(netConn),
-		br:           bufio.NewReader(netConn),
-		readTimeout:  readTimeout,
-		writeTimeout: writeTimeout,
-	}
+	redisConn := NewConnection(netConn, 0, 0)
+	redisConn.rc.ReaderTimeout = readTimeout
+	redisConn.rc.WriterTimeout = writeTimeout
+	return redisConn
 }
 
 func (redisConn *redisConn) LocalAddr() string {
@@ -44,7 +52,11 @@ func (redisConn *redisConn) RemoteAddr() string {
 }
 
 func (redisConn *redisConn) CloseReader() error {
-	if t, ok := redisConn.conn.(*net.TCPConn); ok {
+	sock := redisConn.conn
+	if t, ok := sock.(*tls.Conn); ok {
+		sock = t.NetConn()
+	}
+	if t, ok := sock.(*net.TCPConn); ok {
 		return t.CloseRead()
 	}
 	return redisConn.Close()
@@ -54,17 +66,7 @@ func (redisConn *redisConn) CloseReader() error {
 	set redis connection keep alive period
  */
 func (redisConn *redisConn) SetKeepAlivePeriod(d time.Duration) error {
-	if t, ok := redisConn.conn.(*net.TCPConn); ok {
-		if err := t.SetKeepAlive(d != 0); err != nil {
-			return err
-		}
-		if d != 0 {
-			if err := t.SetKeepAlivePeriod(d); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	return redisConn.rc.SetKeepAlivePeriod(d)
 }
 
 func (redisConn *redisConn) Conn() net.Conn{
@@ -79,6 +81,13 @@ func (redisConn *redisConn) Close() error {
 		err = redisConn.conn.Close()
 	}
 	redisConn.mu.Unlock()
+
+	redisConn.inputMu.Lock()
+	if redisConn.input != nil {
+		close(redisConn.input)
+		redisConn.input = nil
+	}
+	redisConn.inputMu.Unlock()
 	return err
 }
 
@@ -101,35 +110,164 @@ func (redisConn *redisConn) fatal(err error) error {
 	return err
 }
 
-func (redisConn *redisConn) SendBytes(b []byte) error {
-	_, err := redisConn.conn.Write(b)
+/*
+	encode a request, flush immediately if flush is true
+ */
+func (redisConn *redisConn) Send(multi []*redis.Resp, flush bool) error {
+	if err := redisConn.rc.EncodeMultiBulk(multi, flush); err != nil {
+		return redisConn.fatal(err)
+	}
+	return nil
+}
+
+/*
+	decode exactly one complete reply
+ */
+func (redisConn *redisConn) Receive() (*redis.Resp, error) {
+	resp, err := redisConn.rc.Decode()
+	if err != nil {
+		return nil, redisConn.fatal(err)
+	}
+	return resp, nil
+}
+
+/*
+	send a request and wait for its reply
+ */
+func (redisConn *redisConn) Do(multi []*redis.Resp) (*redis.Resp, error) {
+	if err := redisConn.Send(multi, true); err != nil {
+		return nil, err
+	}
+	return redisConn.Receive()
+}
+
+/*
+	兼容单机加密, error reply of AUTH is returned as error
+ */
+func (redisConn *redisConn) Auth(password string) error {
+	var utils utils
+	resp, err := redisConn.Do(utils.auth(password))
 	if err != nil {
 		return err
 	}
+	if resp.IsError() {
+		return redisConn.fatal(errors.Errorf("redis: auth failed, %s", resp.Value))
+	}
 	return nil
 }
 
-func (redisConn *redisConn) peek() int {
-	redisConn.br.Peek(1)
-	length := redisConn.br.Buffered()
-	return length
+/*
+	switch the connection to pipeline mode:
+	loopWriter sends requests without waiting for replies,
+	loopReader matches replies to requests in order.
+	Do/Send/Receive must not be used after run.
+ */
+func (redisConn *redisConn) run(maxPipeline int) {
+	redisConn.inputMu.Lock()
+	defer redisConn.inputMu.Unlock()
+	redisConn.input = make(chan *Request, maxPipeline)
+
+	tasks := make(chan *Request, maxPipeline)
+	go redisConn.loopReader(tasks)
+	go redisConn.loopWriter(redisConn.input, tasks)
+}
+
+/*
+	dispatch a request, Batch is marked done once the reply arrives
+ */
+func (redisConn *redisConn) PushBack(r *Request) {
+	redisConn.pushBack(r)
+}
+
+/*
+	dispatch ASKING followed by the request, no other request in between
+ */
+func (redisConn *redisConn) PushBackAsking(r *Request) {
+	asking := NewRequest([]*redis.Resp{redis.NewBulkBytes([]byte("ASKING"))}, "ASKING")
+	redisConn.pushBack(asking, r)
+}
+
+func (redisConn *redisConn) pushBack(rs ...*Request) {
+	redisConn.inputMu.Lock()
+	defer redisConn.inputMu.Unlock()
+	for _, r := range rs {
+		r.Batch.Add(1)
+		r.Addr, r.Sent = redisConn.addr, time.Now()
+		redisConn.pending.Incr()
+		redisConn.lastUse.Set(r.Sent.UnixNano())
+		if redisConn.input == nil {
+			redisConn.setResponse(r, nil, errors.New("redis: closed"))
+			continue
+		}
+		redisConn.input <- r
+	}
+}
+
+func (redisConn *redisConn) loopWriter(input <-chan *Request, tasks chan<- *Request) {
+	defer close(tasks)
+	p := redisConn.rc.FlushEncoder()
+	p.MaxInterval = backendFlushMaxInterval
+	p.MaxBuffered = backendFlushMaxBuffered
+
+	for r := range input {
+		if err := p.EncodeMultiBulk(r.Multi); err != nil {
+			redisConn.setResponse(r, nil, redisConn.fatal(err))
+			continue
+		}
+		if err := p.Flush(len(input) == 0); err != nil {
+			redisConn.setResponse(r, nil, redisConn.fatal(err))
+			continue
+		}
+		tasks <- r
+	}
+}
+
+func (redisConn *redisConn) loopReader(tasks <-chan *Request) {
+	for r := range tasks {
+		resp, err := redisConn.rc.Decode()
+		if err != nil {
+			err = redisConn.fatal(err)
+		}
+		redisConn.setResponse(r, resp, err)
+	}
 }
 
-func (redisConn *redisConn) Receive() ([]byte, error) {
-	msgLen := redisConn.peek()
-	b := make([]byte, msgLen)
-	n, err := redisConn.br.Read(b)
+func (redisConn *redisConn) setResponse(r *Request, resp *redis.Resp, err error) {
+	r.Resp, r.Err = resp, err
+	r.Backend = time.Since(r.Sent)
+	redisConn.pending.Decr()
+	r.Batch.Done()
+}
+
+/*
+	true if no request is waiting for reply and the connection is unused for d
+ */
+func (redisConn *redisConn) isIdle(d time.Duration) bool {
+	if redisConn.pending.Get() != 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, redisConn.lastUse.Get())) >= d
+}
+
+/*
+	select database, error reply of SELECT is returned as error
+ */
+func (redisConn *redisConn) Select(database int) error {
+	resp, err := redisConn.Do([]*redis.Resp{
+		redis.NewBulkBytes([]byte("SELECT")),
+		redis.NewBulkBytes([]byte(strconv.Itoa(database))),
+	})
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if resp.IsError() {
+		return redisConn.fatal(errors.Errorf("redis: select %d failed, %s", database, resp.Value))
 	}
-	return b[:n], nil
+	return nil
 }
 
 func (redisConn *redisConn) Flush() error {
-	if redisConn.writeTimeout != 0 {
-		redisConn.conn.SetWriteDeadline(time.Now().Add(redisConn.writeTimeout))
-	}
-	if err := redisConn.bw.Flush(); err != nil {
+	if err := redisConn.rc.Flush(); err != nil {
 		return redisConn.fatal(err)
 	}
 	return nil
@@ -145,15 +283,24 @@ func IsTimeout(err error) bool {
 	return false
 }
 
-func DialTimeout(addr string, timeout time.Duration, rbuf, wbuf int) (*redisConn, error) {
-	c, err := net.DialTimeout("tcp", addr, timeout)
+/*
+	dial a backend connection, wrapped in TLS if config is not nil
+ */
+func DialTimeout(addr string, timeout time.Duration, rbuf, wbuf int, config *tls.Config) (*redisConn, error) {
+	c, err := redis.DialSock(addr, timeout, config)
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, err
 	}
 	return NewConnection(c, rbuf, wbuf), nil
 }
 
 func NewConnection(sock net.Conn, rbuf, wbuf int) *redisConn {
-	conn := &redisConn{conn: sock}
+	if rbuf <= 0 {
+		rbuf = 8192
+	}
+	if wbuf <= 0 {
+		wbuf = 8192
+	}
+	conn := &redisConn{conn: sock, rc: redis.NewConn(sock, rbuf, wbuf)}
 	return conn
 }
@@ -1,36 +1,312 @@
 package proxy
 
 import (
-	"net"
-	"bufio"
-	"log"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
 )
 
+/*
+	RedisManager: backend connection pool shared by all clients
+	1.map[redis address]*redisPool, connections are pipelined and multiplexed
+	2.AUTH & SELECT are sent when a connection is dialed
+	3.max active connections per address & database, connections being dialed are counted
+	4.idle connections beyond max idle are evicted after idle timeout
+	5.idle connections are health checked by PING
+ */
+
+var ErrClosedRedisManager = errors.New("use of closed redis manager")
+
 type RedisManager struct {
-	server *Server
-	rc	[]*redisConn
+	mu	sync.Mutex
+	dialed	*sync.Cond	// broadcast when a dial finishes or manager is closed
+	server	*Server
+	pools	map[string]*redisPool	// map[redis address]*redisPool
+
+	exit struct {
+		C chan struct{}
+	}
+	closed	bool
+}
+
+/*
+	connections of a single redis address
+ */
+type redisPool struct {
+	addr	string
+	conns	[]*redisConn
+	dialing	map[int]int	// map[database]connections being dialed
+}
+
+func newRedisPool(addr string) *redisPool {
+	return &redisPool{addr: addr, dialing: make(map[int]int)}
+}
+
+func NewRedisManager(server *Server) *RedisManager {
+	manager := &RedisManager{
+		server: server,
+		pools:  make(map[string]*redisPool),
+	}
+	manager.dialed = sync.NewCond(&manager.mu)
+	manager.exit.C = make(chan struct{})
+
+	period := server.config.BackendPoolHealthCheck.Get()
+	if period == 0 {
+		period = time.Minute
+	}
+	go func() {
+		var ticker = time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-manager.exit.C:
+				return
+			case <-ticker.C:
+				manager.Cleanup()
+			}
+		}
+	}()
+	return manager
+}
+
+/*
+	dial a backend connection with AUTH, not pooled and not running
+ */
+func (manager *RedisManager) connect(addr string) (*redisConn, error) {
+	config := manager.server.config
+	rConn, err := DialTimeout(addr, config.BackendDialTimeout.Get(),
+		config.BackendRecvBufsize.Int(), config.BackendSendBufsize.Int(), manager.server.backendTLS)
+	if err != nil {
+		return nil, err
+	}
+	rConn.rc.ReaderTimeout = config.BackendRecvTimeout.Get()
+	rConn.rc.WriterTimeout = config.BackendSendTimeout.Get()
+	rConn.server = manager.server
+	rConn.addr = addr
+	rConn.password = config.BackendAuth
+	// 兼容单机模式加密
+	if rConn.password != "" {
+		if err := rConn.Auth(rConn.password); err != nil {
+			rConn.Close()
+			return nil, err
+		}
+	}
+	return rConn, nil
 }
 
-func (manager *RedisManager) add(addr string) {
-	rc, err := net.Dial("tcp", addr)
+/*
+	dial a backend connection, AUTH and SELECT before it is used
+ */
+func (manager *RedisManager) dial(addr string, database int) (*redisConn, error) {
+	config := manager.server.config
+	rConn, err := manager.connect(addr)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	redisConn := &redisConn{
-		conn: rc,
-		server: manager.server,
-		br: bufio.NewReader(rc),
-		bw: bufio.NewWriter(rc),
+	rConn.database = database
+	if database != 0 {
+		if err := rConn.Select(database); err != nil {
+			rConn.Close()
+			return nil, err
+		}
 	}
-	manager.rc = append(manager.rc ,redisConn)
+	rConn.lastUse.Set(time.Now().UnixNano())
+	rConn.run(config.BackendMaxPipeline)
+	return rConn, nil
 }
 
-func (manager *RedisManager) remove(addr string) {
-	for i := 0; i < len(manager.rc); i++ {
-		if manager.rc[i].conn.RemoteAddr().String() == addr{
-			manager.rc[i].conn.Close()
-			manager.rc[i] = nil
+/*
+	checkout a connection of addr with database selected:
+	1.an idle connection
+	2.a new connection if there are less than max active
+	3.the connection with least pending requests
+	4.wait for connections being dialed if all the slots are taken by them
+ */
+func (manager *RedisManager) Get(addr string, database int) (*redisConn, error) {
+	manager.mu.Lock()
+	var pool *redisPool
+	var best *redisConn
+	for {
+		if manager.closed {
+			manager.mu.Unlock()
+			return nil, ErrClosedRedisManager
+		}
+		pool = manager.pools[addr]
+		if pool == nil {
+			pool = newRedisPool(addr)
+			manager.pools[addr] = pool
+		}
+		var active = pool.dialing[database]
+		best = nil
+		for _, rConn := range pool.conns {
+			if rConn.database != database || rConn.Err() != nil {
+				continue
+			}
+			if rConn.pending.Get() == 0 {
+				manager.mu.Unlock()
+				return rConn, nil
+			}
+			if best == nil || rConn.pending.Get() < best.pending.Get() {
+				best = rConn
+			}
+			active++
+		}
+		if active < manager.server.config.BackendPoolMaxActive {
 			break
 		}
+		if best != nil {
+			manager.mu.Unlock()
+			return best, nil
+		}
+		manager.dialed.Wait()
+	}
+	// 占用一个名额, 在锁外建立连接, 避免阻塞其他地址
+	pool.dialing[database]++
+	manager.mu.Unlock()
+
+	rConn, err := manager.dial(addr, database)
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	defer manager.dialed.Broadcast()
+	if pool.dialing[database]--; pool.dialing[database] == 0 {
+		delete(pool.dialing, database)
+	}
+	if err != nil {
+		if best != nil {
+			return best, nil
+		}
+		return nil, err
+	}
+	if manager.closed {
+		rConn.Close()
+		return nil, ErrClosedRedisManager
+	}
+	pool = manager.pools[addr]
+	if pool == nil {
+		pool = newRedisPool(addr)
+		manager.pools[addr] = pool
+	}
+	pool.conns = append(pool.conns, rConn)
+	return rConn, nil
+}
+
+/*
+	close all connections of addr
+ */
+func (manager *RedisManager) remove(addr string) {
+	manager.mu.Lock()
+	pool := manager.pools[addr]
+	delete(manager.pools, addr)
+	manager.mu.Unlock()
+
+	if pool != nil {
+		for _, rConn := range pool.conns {
+			rConn.Close()
+		}
+	}
+}
+
+/*
+	pool state of every address: map[redis address]number of connections
+ */
+func (manager *RedisManager) Stats() map[string]int {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	stats := make(map[string]int, len(manager.pools))
+	for addr, pool := range manager.pools {
+		stats[addr] = len(pool.conns)
+	}
+	return stats
+}
+
+/*
+	1.remove broken connections
+	2.evict idle connections beyond max idle
+	3.PING the remaining idle connections
+ */
+func (manager *RedisManager) Cleanup() error {
+	config := manager.server.config
+
+	manager.mu.Lock()
+	if manager.closed {
+		manager.mu.Unlock()
+		return ErrClosedRedisManager
+	}
+	var check []*redisConn
+	for addr, pool := range manager.pools {
+		var conns []*redisConn
+		var idle int
+		for _, rConn := range pool.conns {
+			if rConn.Err() != nil {
+				rConn.Close()
+				continue
+			}
+			if rConn.isIdle(config.BackendPoolIdleTimeout.Get()) {
+				if idle >= config.BackendPoolMaxIdle {
+					rConn.Close()
+					continue
+				}
+				idle++
+			}
+			if period := config.BackendPoolHealthCheck.Get(); period != 0 && rConn.isIdle(period) {
+				check = append(check, rConn)
+			}
+			conns = append(conns, rConn)
+		}
+		pool.conns = conns
+		if len(conns) == 0 && len(pool.dialing) == 0 {
+			delete(manager.pools, addr)
+		}
+	}
+	manager.mu.Unlock()
+
+	for _, rConn := range check {
+		if err := manager.ping(rConn); err != nil {
+			log.WarnErrorf(err, "backend %s health check failed", rConn.addr)
+			rConn.fatal(err)
+		}
+	}
+	return nil
+}
+
+/*
+	health check by PING through the pipeline
+ */
+func (manager *RedisManager) ping(rConn *redisConn) error {
+	r := NewRequest([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))}, "PING")
+	rConn.PushBack(r)
+	r.Batch.Wait()
+	if r.Err != nil {
+		return r.Err
+	}
+	if r.Resp.IsError() {
+		return errors.Errorf("PING failed, %s", r.Resp.Value)
+	}
+	return nil
+}
+
+/*
+	close manager and all backend connections
+ */
+func (manager *RedisManager) Close() error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.closed {
+		return nil
+	}
+	manager.closed = true
+	close(manager.exit.C)
+	manager.dialed.Broadcast()
+
+	for addr, pool := range manager.pools {
+		for _, rConn := range pool.conns {
+			rConn.Close()
+		}
+		delete(manager.pools, addr)
 	}
+	return nil
 }
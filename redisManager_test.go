@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"sync"
+	"testing"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+func newTestManager(addr string) *RedisManager {
+	config := NewDefaultConfig()
+	config.BackendAddrs = []string{addr}
+	config.BackendPoolMaxActive = 2
+	config.BackendPoolMaxIdle = 1
+	config.BackendPoolIdleTimeout = 0
+	server, err := NewServer(config)
+	assert.MustNoError(err)
+	return server.manager
+}
+
+func pushTestRequest(rConn *redisConn, cmd string) *Request {
+	r := NewRequest([]*redis.Resp{redis.NewBulkBytes([]byte(cmd))}, cmd)
+	rConn.PushBack(r)
+	return r
+}
+
+func TestRedisManagerGet(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+	addr := backend.Addr().String()
+
+	manager := newTestManager(addr)
+	defer manager.Close()
+
+	// idle connection is reused
+	c1, err := manager.Get(addr, 0)
+	assert.MustNoError(err)
+	c2, err := manager.Get(addr, 0)
+	assert.MustNoError(err)
+	assert.Must(c1 == c2)
+
+	// busy connection, dial a new one until max active
+	r1 := pushTestRequest(c1, "SLEEP")
+	c2, err = manager.Get(addr, 0)
+	assert.MustNoError(err)
+	assert.Must(c1 != c2)
+	r2 := pushTestRequest(c2, "SLEEP")
+	r3 := pushTestRequest(c2, "SLEEP")
+	c3, err := manager.Get(addr, 0)
+	assert.MustNoError(err)
+	assert.Must(c3 == c1)
+	assert.Must(manager.Stats()[addr] == 2)
+
+	// connection selected another database is not shared
+	c4, err := manager.Get(addr, 3)
+	assert.MustNoError(err)
+	assert.Must(c4 != c1 && c4 != c2 && c4.database == 3)
+
+	for _, r := range []*Request{r1, r2, r3} {
+		r.Batch.Wait()
+		assert.MustNoError(r.Err)
+		assert.Must(r.Resp.IsString())
+	}
+}
+
+func TestRedisManagerCleanup(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+	addr := backend.Addr().String()
+
+	manager := newTestManager(addr)
+	defer manager.Close()
+
+	c1, err := manager.Get(addr, 0)
+	assert.MustNoError(err)
+	r := pushTestRequest(c1, "SLEEP")
+	c2, err := manager.Get(addr, 0)
+	assert.MustNoError(err)
+	r.Batch.Wait()
+	assert.Must(manager.Stats()[addr] == 2)
+
+	// evict idle connections beyond max idle
+	assert.MustNoError(manager.Cleanup())
+	assert.Must(manager.Stats()[addr] == 1)
+	assert.Must(c2.Err() != nil)
+
+	// broken connection is removed
+	pushTestRequest(c1, "CRASH").Batch.Wait()
+	assert.Must(c1.Err() != nil)
+	assert.MustNoError(manager.Cleanup())
+	assert.Must(manager.Stats()[addr] == 0)
+}
+
+func TestRedisManagerMaxActive(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+	addr := backend.Addr().String()
+
+	manager := newTestManager(addr)
+	defer manager.Close()
+
+	// concurrent checkouts on an empty pool don't dial beyond max active
+	var wg sync.WaitGroup
+	var start = make(chan struct{})
+	var conns = make(chan *redisConn, 32)
+	for i := 0; i < cap(conns); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			rConn, err := manager.Get(addr, 0)
+			assert.MustNoError(err)
+			pushTestRequest(rConn, "SLEEP")
+			conns <- rConn
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(conns)
+
+	var distinct = make(map[*redisConn]bool)
+	for rConn := range conns {
+		distinct[rConn] = true
+	}
+	assert.Must(len(distinct) <= 2 && manager.Stats()[addr] <= 2)
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/redis"
+)
+
+/*
+	read/write splitting in standalone and sentinel mode
+	1.replicas are discovered from INFO replication of the master
+	2.replicas whose link is down or lag exceeds replica_max_lag are skipped
+	3.read-only commands go to a replica chosen by read_policy, others to the master
+	  master-only    : never read from replicas
+	  prefer-replica : replicas in turn, the master if none is available
+	  nearest        : the node (master included) with least PING latency
+ */
+
+const (
+	ReadPolicyMasterOnly    = "master-only"
+	ReadPolicyPreferReplica = "prefer-replica"
+	ReadPolicyNearest       = "nearest"
+)
+
+type replicaNode struct {
+	addr    string
+	latency time.Duration
+}
+
+/*
+	backend of a read-only request, empty means the master
+ */
+func (router *Router) pickReplica(r *Request) string {
+	policy := router.server.config.ReadPolicy
+	if policy == ReadPolicyMasterOnly {
+		return ""
+	}
+	if c := commandTable[r.OpStr]; c == nil || !c.hasFlag(flagReadOnly) || c.hasFlag(flagAdmin) {
+		return ""
+	}
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+	if len(router.replicas) == 0 {
+		return ""
+	}
+	switch policy {
+	case ReadPolicyNearest:
+		var best *replicaNode
+		for _, node := range router.replicas {
+			if best == nil || node.latency < best.latency {
+				best = node
+			}
+		}
+		if best.latency >= router.masterLatency {
+			return ""
+		}
+		return best.addr
+	default:
+		n := router.replicaNext.Incr()
+		return router.replicas[int(n%int64(len(router.replicas)))].addr
+	}
+}
+
+func (router *Router) loopReplicas() {
+	var ticker = time.NewTicker(router.server.config.ReplicaRefreshPeriod.Get())
+	defer ticker.Stop()
+	for {
+		router.refreshReplicas()
+		select {
+		case <-router.exit.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+	reload healthy replicas of the current master
+ */
+func (router *Router) refreshReplicas() {
+	config := router.server.config
+	timeout := config.BackendDialTimeout.Get()
+
+	master := config.BackendAddrs[0]
+	if config.BackendMode == BackendModeSentinel {
+		router.mu.RLock()
+		master = router.master
+		router.mu.RUnlock()
+		if master == "" {
+			return
+		}
+	}
+
+	var masterLatency time.Duration
+	var replicas []*replicaNode
+	c, err := redis.NewClientTLS(master, config.BackendAuth, timeout, router.server.backendTLS)
+	if err != nil {
+		log.WarnErrorf(err, "refresh replicas of %s failed", master)
+	} else {
+		var info map[string]string
+		if info, err = c.Info(); err == nil {
+			masterLatency, err = pingLatency(c)
+		}
+		if err != nil {
+			log.WarnErrorf(err, "refresh replicas of %s failed", master)
+		} else {
+			replicas = router.probeReplicas(master, info)
+		}
+		c.Close()
+	}
+
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.replicas = replicas
+	router.masterLatency = masterLatency
+}
+
+/*
+	parse slaveN:ip=127.0.0.1,port=6380,state=online,offset=1,lag=0 from master INFO
+	and check every online replica by its own INFO
+ */
+func (router *Router) probeReplicas(master string, info map[string]string) []*replicaNode {
+	config := router.server.config
+	maxLag := config.ReplicaMaxLag.Get()
+
+	var replicas []*replicaNode
+	for key, value := range info {
+		if !strings.HasPrefix(key, "slave") {
+			continue
+		}
+		if _, err := strconv.Atoi(key[5:]); err != nil {
+			continue
+		}
+		var fields = make(map[string]string)
+		for _, kv := range strings.Split(value, ",") {
+			if p := strings.SplitN(kv, "=", 2); len(p) == 2 {
+				fields[p[0]] = p[1]
+			}
+		}
+		if fields["state"] != "online" || fields["ip"] == "" || fields["port"] == "" {
+			continue
+		}
+		addr := net.JoinHostPort(fields["ip"], fields["port"])
+
+		c, err := redis.NewClientTLS(addr, config.BackendAuth, config.BackendDialTimeout.Get(), router.server.backendTLS)
+		if err != nil {
+			log.WarnErrorf(err, "probe replica %s of %s failed", addr, master)
+			continue
+		}
+		var latency time.Duration
+		rinfo, err := c.InfoFull()
+		if err == nil {
+			latency, err = pingLatency(c)
+		}
+		c.Close()
+		if err != nil {
+			log.WarnErrorf(err, "probe replica %s of %s failed", addr, master)
+			continue
+		}
+		if rinfo["master_link_status"] != "up" {
+			continue
+		}
+		if maxLag != 0 {
+			lag, err := strconv.Atoi(rinfo["master_last_io_seconds_ago"])
+			if err != nil || lag < 0 || time.Duration(lag)*time.Second > maxLag {
+				continue
+			}
+		}
+		replicas = append(replicas, &replicaNode{addr: addr, latency: latency})
+	}
+	return replicas
+}
+
+/*
+	round trip of PING, the same command is timed on the master and replicas
+ */
+func pingLatency(c *redis.Client) (time.Duration, error) {
+	var start = time.Now()
+	if _, err := c.Do("PING"); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+	"SSAWPROXY/redisProxy/utils/timesize"
+)
+
+/*
+	fake redis node for tests:
+	INFO -> info text, CONFIG GET maxmemory -> 0, PING -> +PONG, others -> +name of the node
+ */
+type fakeNode struct {
+	net.Listener
+
+	mu    sync.Mutex
+	name  string
+	info  string
+	pings int
+}
+
+func newFakeNode(name string) *fakeNode {
+	fn := &fakeNode{name: name}
+	fn.Listener = newFakeServer(func() fakeHandler {
+		return fn.handle
+	})
+	return fn
+}
+
+func (fn *fakeNode) setInfo(lines ...string) {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	fn.info = strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func (fn *fakeNode) handle(c net.Conn, multi []string) string {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	switch strings.ToUpper(multi[0]) {
+	case "PING":
+		fn.pings++
+		return "+PONG\r\n"
+	case "INFO":
+		return fakeBulk(fn.info)
+	case "CONFIG":
+		return "*2\r\n" + fakeBulk("maxmemory") + fakeBulk("0")
+	}
+	return "+" + fn.name + "\r\n"
+}
+
+func TestReadPolicy(t *testing.T) {
+	master := newFakeNode("master")
+	defer master.Close()
+	replica := newFakeNode("replica")
+	defer replica.Close()
+
+	host, port, _ := net.SplitHostPort(replica.Addr().String())
+	master.setInfo("role:master", "connected_slaves:1",
+		"slave0:ip="+host+",port="+port+",state=online,offset=100,lag=0")
+	replica.setInfo("role:slave", "master_link_status:up", "master_last_io_seconds_ago:1")
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{master.Addr().String()}
+	config.ReadPolicy = ReadPolicyPreferReplica
+	config.ReplicaRefreshPeriod = timesize.Duration(time.Millisecond * 50)
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	waitReplicas := func(n int) {
+		for i := 0; i < 200; i++ {
+			server.router.mu.RLock()
+			replicas := len(server.router.replicas)
+			server.router.mu.RUnlock()
+			if replicas == n {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		assert.Must(false)
+	}
+
+	waitReplicas(1)
+	// latency of replicas is measured by PING as the master
+	replica.mu.Lock()
+	assert.Must(replica.pings != 0)
+	replica.mu.Unlock()
+	assert.Must(getProxy(c, r, "foo") == "+replica")
+	_, err = c.Write([]byte("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"))
+	assert.MustNoError(err)
+	line, err := r.ReadString('\n')
+	assert.MustNoError(err)
+	assert.Must(line == "+master\r\n")
+
+	// replica lags behind, reads go back to master
+	replica.setInfo("role:slave", "master_link_status:up", "master_last_io_seconds_ago:60")
+	waitReplicas(0)
+	assert.Must(getProxy(c, r, "foo") == "+master")
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+)
+
+/*
+	request: a decoded client command travelling through the pipeline
+	1.client loopReader creates it and dispatches it to backend
+	2.backend loopReader fills Resp/Err and marks Batch done
+	3.client loopWriter waits on Batch and replies in original order
+ */
+
+type Request struct {
+	Multi	[]*redis.Resp
+	Batch	*sync.WaitGroup
+
+	OpStr	string
+	Start	time.Time
+
+	Database	int	// selected by the session, see Client.database
+
+	Resp	*redis.Resp
+	Err	error
+
+	Addr	string		// backend address, set on dispatch
+	Sent	time.Time	// dispatched to backend
+	Backend	time.Duration	// from dispatch to the backend reply, redirects included
+
+	Subs	[]*Request	// multi-key command split by slot
+	Index	[]int		// key positions of a sub request in its parent
+
+	PubSub	bool		// sent by subscription connections of the session
+
+	Pinned	bool		// sent by a transaction or blocking command, never redirected
+	Txn	[]*Request	// MULTI & queued commands sent before EXEC
+}
+
+func NewRequest(multi []*redis.Resp, opstr string) *Request {
+	return &Request{
+		Multi: multi,
+		Batch: &sync.WaitGroup{},
+		OpStr: opstr,
+		Start: time.Now(),
+	}
+}
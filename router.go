@@ -0,0 +1,428 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/sync2/atomic2"
+)
+
+/*
+	router: choose backend redis for every request
+	1.standalone: the only backend address
+	2.cluster: slot of the key -> node, topology cached from CLUSTER SLOTS
+	  -MOVED updates the slot and triggers a refresh
+	  -ASK is sent to the target node after ASKING
+	3.sentinel: the master discovered by sentinels
+	4.read-only commands may go to replicas in standalone and sentinel mode
+	5.lua scripts, see script.go
+ */
+
+const maxRedirects = 5
+
+var ErrClosedRouter = errors.New("use of closed router")
+
+type Router struct {
+	mu	sync.RWMutex
+	server	*Server
+	slots	[redis.MaxSlotNum]string	// cluster mode: slot -> node address
+	master	string				// sentinel mode: current master address
+
+	replicas	[]*replicaNode		// healthy replicas of the master
+	replicaNext	atomic2.Int64
+	masterLatency	time.Duration
+
+	scripts		scriptCache		// lua scripts by SHA1
+
+	refresh struct {
+		C chan struct{}
+	}
+	exit struct {
+		C chan struct{}
+	}
+	closed	bool
+}
+
+func NewRouter(server *Server) *Router {
+	router := &Router{server: server}
+	router.refresh.C = make(chan struct{}, 1)
+	router.exit.C = make(chan struct{})
+
+	switch server.config.BackendMode {
+	case BackendModeCluster:
+		router.triggerRefresh()
+		go router.loopRefresh()
+	case BackendModeSentinel:
+		go router.loopSentinel()
+	}
+	if server.config.ReadPolicy != ReadPolicyMasterOnly {
+		go router.loopReplicas()
+	}
+	return router
+}
+
+func (router *Router) Close() error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	if router.closed {
+		return nil
+	}
+	router.closed = true
+	close(router.exit.C)
+	return nil
+}
+
+/*
+	the key used to compute slot, nil if the command has no key
+	commands missing in the command table and SPUBLISH use the first argument
+ */
+func getHashKey(multi []*redis.Resp, opstr string) []byte {
+	if commandTable[opstr] == nil || opstr == "SPUBLISH" {
+		if len(multi) > 1 {
+			return multi[1].Value
+		}
+		return nil
+	}
+	if keys := getKeys(multi, opstr); len(keys) != 0 {
+		return keys[0]
+	}
+	return nil
+}
+
+/*
+	backend address of a request
+ */
+func (router *Router) lookup(r *Request) string {
+	config := router.server.config
+	switch config.BackendMode {
+	case BackendModeStandalone, BackendModeSentinel:
+		if addr := router.pickReplica(r); addr != "" {
+			return addr
+		}
+		return router.masterAddr()
+	}
+	return router.lookupKey(getHashKey(r.Multi, r.OpStr))
+}
+
+/*
+	the master in standalone and sentinel mode, any node in cluster mode
+ */
+func (router *Router) masterAddr() string {
+	config := router.server.config
+	switch config.BackendMode {
+	case BackendModeStandalone:
+		return config.BackendAddrs[0]
+	case BackendModeSentinel:
+		router.mu.RLock()
+		defer router.mu.RUnlock()
+		return router.master
+	}
+	return router.anyNode()
+}
+
+/*
+	backend of sharded pub/sub channel
+ */
+func (router *Router) lookupChannel(channel []byte) string {
+	if router.server.config.BackendMode != BackendModeCluster {
+		return router.masterAddr()
+	}
+	return router.lookupKey(channel)
+}
+
+/*
+	cluster mode: node of the slot of key
+ */
+func (router *Router) lookupKey(key []byte) string {
+	if key != nil {
+		router.mu.RLock()
+		addr := router.slots[redis.HashSlot(key)]
+		router.mu.RUnlock()
+		if addr != "" {
+			return addr
+		}
+	}
+	// 没有key或拓扑未知, 发送到任意节点, 由-MOVED纠正
+	return router.anyNode()
+}
+
+func (router *Router) anyNode() string {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+	for _, addr := range router.slots {
+		if addr != "" {
+			return addr
+		}
+	}
+	return router.server.config.BackendAddrs[0]
+}
+
+/*
+	dispatch request to its backend without waiting
+ */
+func (router *Router) dispatch(r *Request) error {
+	switch r.OpStr {
+	case "EVAL", "EVALSHA", "SCRIPT":
+		return router.dispatchScript(r)
+	case "INFO":
+		return router.dispatchInfo(r)
+	}
+	if r.Subs = router.split(r); r.Subs != nil {
+		// 子请求的错误在coalesce时返回
+		for _, sub := range r.Subs {
+			if err := router.dispatchAddr(sub, router.lookup(sub)); err != nil {
+				sub.Err = err
+			}
+		}
+		return nil
+	}
+	return router.dispatchAddr(r, router.lookup(r))
+}
+
+func (router *Router) dispatchAddr(r *Request, addr string) error {
+	if addr == "" {
+		return ErrNoMaster
+	}
+	rConn, err := router.server.manager.Get(addr, r.Database)
+	if err != nil {
+		return err
+	}
+	// 向redis发送数据, 不等待返回
+	rConn.PushBack(r)
+	return nil
+}
+
+/*
+	parse "-MOVED slot addr" or "-ASK slot addr"
+ */
+func parseRedirect(resp *redis.Resp) (ask bool, slot int, addr string, ok bool) {
+	if resp == nil || !resp.IsError() {
+		return
+	}
+	switch {
+	case bytes.HasPrefix(resp.Value, []byte("MOVED ")):
+	case bytes.HasPrefix(resp.Value, []byte("ASK ")):
+		ask = true
+	default:
+		return
+	}
+	fields := strings.Fields(string(resp.Value))
+	if len(fields) != 3 {
+		return
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n < 0 || n >= redis.MaxSlotNum {
+		return
+	}
+	return ask, n, fields[2], true
+}
+
+/*
+	wait for the reply of a request, merge replies of split multi-key commands
+ */
+func (router *Router) handleResponse(r *Request) (*redis.Resp, error) {
+	switch {
+	case r.Subs != nil:
+		return router.coalesce(r)
+	case r.Pinned:
+		return router.handleTransaction(r)
+	case r.OpStr == "EVALSHA":
+		return router.handleEvalSha(r)
+	}
+	return router.redirect(r)
+}
+
+/*
+	wait for the reply of a request, follow -MOVED/-ASK in cluster mode
+ */
+func (router *Router) redirect(r *Request) (*redis.Resp, error) {
+	r.Batch.Wait()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	resp := r.Resp
+	for i := 0; i < maxRedirects; i++ {
+		ask, slot, addr, ok := parseRedirect(resp)
+		if !ok {
+			return resp, nil
+		}
+		router.server.stats.redirect(ask)
+		redo := NewRequest(r.Multi, r.OpStr)
+		redo.Database = r.Database
+		if ask {
+			rConn, err := router.server.manager.Get(addr, r.Database)
+			if err != nil {
+				return nil, errors.Errorf("redirect to %s failed, %s", addr, err)
+			}
+			rConn.PushBackAsking(redo)
+		} else {
+			router.setSlot(slot, addr)
+			router.triggerRefresh()
+			if err := router.dispatchAddr(redo, addr); err != nil {
+				return nil, errors.Errorf("redirect to %s failed, %s", addr, err)
+			}
+		}
+		redo.Batch.Wait()
+		r.Addr, r.Backend = redo.Addr, r.Backend+redo.Backend
+		if redo.Err != nil {
+			return nil, errors.Errorf("redirect to %s failed, %s", addr, redo.Err)
+		}
+		resp = redo.Resp
+	}
+	return resp, nil
+}
+
+func (router *Router) setSlot(slot int, addr string) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.slots[slot] = addr
+}
+
+/*
+	ask loopRefresh to reload topology, never blocks
+ */
+func (router *Router) triggerRefresh() {
+	select {
+	case router.refresh.C <- struct{}{}:
+	default:
+	}
+}
+
+func (router *Router) loopRefresh() {
+	// period为0时只在-MOVED时刷新
+	var tick <-chan time.Time
+	if period := router.server.config.ClusterRefreshPeriod.Get(); period > 0 {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-router.exit.C:
+			return
+		case <-router.refresh.C:
+		case <-tick:
+		}
+		if err := router.Refresh(); err != nil {
+			log.WarnErrorf(err, "refresh cluster slots failed")
+		}
+	}
+}
+
+/*
+	reload topology now: slots in cluster mode, the master in sentinel mode,
+	then replicas if read_policy is not master-only
+ */
+func (router *Router) Reload() error {
+	var err error
+	switch router.server.config.BackendMode {
+	case BackendModeCluster:
+		err = router.Refresh()
+	case BackendModeSentinel:
+		err = router.refreshMaster()
+	}
+	if err != nil {
+		return err
+	}
+	if router.server.config.ReadPolicy != ReadPolicyMasterOnly {
+		router.refreshReplicas()
+	}
+	return nil
+}
+
+/*
+	reload slots from CLUSTER SLOTS of any known node
+ */
+func (router *Router) Refresh() error {
+	var nodes []string
+	var seen = make(map[string]bool)
+	router.mu.RLock()
+	for _, addr := range router.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+	}
+	router.mu.RUnlock()
+	for _, addr := range router.server.config.BackendAddrs {
+		if !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+	}
+
+	var lastErr error
+	for _, addr := range nodes {
+		slots, err := router.clusterSlots(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		router.mu.Lock()
+		router.slots = *slots
+		router.mu.Unlock()
+		return nil
+	}
+	return lastErr
+}
+
+func (router *Router) clusterSlots(addr string) (*[redis.MaxSlotNum]string, error) {
+	r := NewRequest([]*redis.Resp{
+		redis.NewBulkBytes([]byte("CLUSTER")),
+		redis.NewBulkBytes([]byte("SLOTS")),
+	}, "CLUSTER")
+	if err := router.dispatchAddr(r, addr); err != nil {
+		return nil, err
+	}
+	r.Batch.Wait()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return parseClusterSlots(r.Resp, addr)
+}
+
+/*
+	CLUSTER SLOTS reply:
+	1) 1) (integer) 0           start slot
+	   2) (integer) 5460        end slot
+	   3) 1) "127.0.0.1"        master ip, empty means the node replying
+	      2) (integer) 7000     master port
+	   4) ...                   replicas
+ */
+func parseClusterSlots(resp *redis.Resp, from string) (*[redis.MaxSlotNum]string, error) {
+	if resp.IsError() {
+		return nil, errors.Errorf("cluster slots of %s failed, %s", from, resp.Value)
+	}
+	if !resp.IsArray() {
+		return nil, errors.Errorf("cluster slots of %s, bad reply type %s", from, resp.Type)
+	}
+	fromHost, _, _ := net.SplitHostPort(from)
+
+	var slots [redis.MaxSlotNum]string
+	for _, e := range resp.Array {
+		if !e.IsArray() || len(e.Array) < 3 || !e.Array[2].IsArray() || len(e.Array[2].Array) < 2 {
+			return nil, errors.Errorf("cluster slots of %s, bad slot range", from)
+		}
+		beg, err1 := redis.Btoi64(e.Array[0].Value)
+		end, err2 := redis.Btoi64(e.Array[1].Value)
+		if err1 != nil || err2 != nil || beg < 0 || beg > end || end >= redis.MaxSlotNum {
+			return nil, errors.Errorf("cluster slots of %s, bad slot range", from)
+		}
+		host := string(e.Array[2].Array[0].Value)
+		if host == "" || host == "?" {
+			host = fromHost
+		}
+		addr := net.JoinHostPort(host, string(e.Array[2].Array[1].Value))
+		for i := beg; i <= end; i++ {
+			slots[i] = addr
+		}
+	}
+	return &slots, nil
+}
@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	fake redis cluster for tests:
+	CLUSTER SLOTS -> slots table, GET key of the node -> +address of the node,
+	MGET -> keys, MSET -> +OK, others -> number of keys,
+	keys of other nodes -> -MOVED, slots in asking -> -ASK unless ASKING is sent first,
+	keys in different slots -> -CROSSSLOT
+ */
+type fakeCluster struct {
+	mu     sync.Mutex
+	nodes  []net.Listener
+	slots  [redis.MaxSlotNum]int
+	asking map[int]int
+}
+
+func newFakeCluster(n int) *fakeCluster {
+	fc := &fakeCluster{asking: make(map[int]int)}
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.MustNoError(err)
+		fc.nodes = append(fc.nodes, l)
+	}
+	for i := range fc.slots {
+		fc.slots[i] = i * n / redis.MaxSlotNum
+	}
+	for i, l := range fc.nodes {
+		fc.serve(i, l)
+	}
+	return fc
+}
+
+func (fc *fakeCluster) addr(i int) string {
+	return fc.nodes[i].Addr().String()
+}
+
+func (fc *fakeCluster) Close() {
+	for _, l := range fc.nodes {
+		l.Close()
+	}
+}
+
+func (fc *fakeCluster) serve(id int, l net.Listener) {
+	serveFake(l, func() fakeHandler {
+		var asking bool
+		return func(c net.Conn, multi []string) string {
+			switch strings.ToUpper(multi[0]) {
+			case "PING":
+				return "+PONG\r\n"
+			case "ASKING":
+				asking = true
+				return "+OK\r\n"
+			case "CLUSTER":
+				return fc.clusterSlots()
+			}
+			defer func() {
+				asking = false
+			}()
+			return fc.handle(id, multi, asking)
+		}
+	})
+}
+
+func (fc *fakeCluster) handle(id int, multi []string, asking bool) string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var op, step = strings.ToUpper(multi[0]), 1
+	if op == "MSET" {
+		step = 2
+	}
+	var keys []string
+	for i := 1; i < len(multi); i += step {
+		keys = append(keys, multi[i])
+	}
+	slot := redis.HashSlot([]byte(keys[0]))
+	for _, key := range keys {
+		if redis.HashSlot([]byte(key)) != slot {
+			return "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+		}
+	}
+	owner := fc.slots[slot]
+	if target, ok := fc.asking[slot]; ok {
+		if id == owner {
+			return "-ASK " + strconv.Itoa(slot) + " " + fc.addr(target) + "\r\n"
+		}
+		if id == target && asking {
+			owner = id
+		}
+	}
+	if id != owner {
+		return "-MOVED " + strconv.Itoa(slot) + " " + fc.addr(owner) + "\r\n"
+	}
+	switch op {
+	case "GET":
+		return "+" + fc.addr(id) + "\r\n"
+	case "MGET":
+		var b = "*" + strconv.Itoa(len(keys)) + "\r\n"
+		for _, key := range keys {
+			b += fakeBulk(key)
+		}
+		return b
+	case "MSET":
+		return "+OK\r\n"
+	default:
+		return ":" + strconv.Itoa(len(keys)) + "\r\n"
+	}
+}
+
+func (fc *fakeCluster) clusterSlots() string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var ranges []string
+	for beg := 0; beg < redis.MaxSlotNum; {
+		end := beg
+		for end+1 < redis.MaxSlotNum && fc.slots[end+1] == fc.slots[beg] {
+			end++
+		}
+		host, port, _ := net.SplitHostPort(fc.addr(fc.slots[beg]))
+		ranges = append(ranges, "*3\r\n:"+strconv.Itoa(beg)+"\r\n:"+strconv.Itoa(end)+"\r\n"+
+			"*2\r\n$"+strconv.Itoa(len(host))+"\r\n"+host+"\r\n:"+port+"\r\n")
+		beg = end + 1
+	}
+	return "*" + strconv.Itoa(len(ranges)) + "\r\n" + strings.Join(ranges, "")
+}
+
+func newTestClusterServer(fc *fakeCluster) (*Server, chan error) {
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendMode = BackendModeCluster
+	config.BackendAddrs = []string{fc.addr(0)}
+	return startTestServer(config)
+}
+
+func getProxy(c net.Conn, r *bufio.Reader, key string) string {
+	_, err := c.Write([]byte("*2\r\n$3\r\nGET\r\n$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n"))
+	assert.MustNoError(err)
+	line, err := r.ReadString('\n')
+	assert.MustNoError(err)
+	return strings.TrimSpace(line)
+}
+
+func TestParseRedirect(t *testing.T) {
+	ask, slot, addr, ok := parseRedirect(redis.NewErrorf("MOVED 3999 127.0.0.1:6381"))
+	assert.Must(ok && !ask && slot == 3999 && addr == "127.0.0.1:6381")
+
+	ask, slot, addr, ok = parseRedirect(redis.NewErrorf("ASK 12182 127.0.0.1:6382"))
+	assert.Must(ok && ask && slot == 12182 && addr == "127.0.0.1:6382")
+
+	for _, resp := range []*redis.Resp{
+		redis.NewString([]byte("MOVED 3999 127.0.0.1:6381")),
+		redis.NewErrorf("ERR unknown command"),
+		redis.NewErrorf("MOVED 16384 127.0.0.1:6381"),
+		redis.NewErrorf("MOVED 3999"),
+	} {
+		_, _, _, ok := parseRedirect(resp)
+		assert.Must(!ok)
+	}
+}
+
+func TestGetHashKey(t *testing.T) {
+	multi := []*redis.Resp{
+		redis.NewBulkBytes([]byte("EVAL")),
+		redis.NewBulkBytes([]byte("return 1")),
+		redis.NewBulkBytes([]byte("1")),
+		redis.NewBulkBytes([]byte("key")),
+	}
+	assert.Must(string(getHashKey(multi, "EVAL")) == "key")
+	assert.Must(string(getHashKey(multi[:2], "GET")) == "return 1")
+	assert.Must(getHashKey(multi[:1], "PING") == nil)
+}
+
+func TestRouterCluster(t *testing.T) {
+	fc := newFakeCluster(2)
+	defer fc.Close()
+
+	server, errc := newTestClusterServer(fc)
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	r := bufio.NewReader(c)
+	// foo -> slot 12182, bar -> slot 5061
+	assert.Must(getProxy(c, r, "foo") == "+"+fc.addr(1))
+	assert.Must(getProxy(c, r, "bar") == "+"+fc.addr(0))
+
+	for i := 0; i < 100; i++ {
+		server.router.mu.RLock()
+		addr := server.router.slots[12182]
+		server.router.mu.RUnlock()
+		if addr == fc.addr(1) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Must(server.router.lookup(NewRequest([]*redis.Resp{
+		redis.NewBulkBytes([]byte("GET")), redis.NewBulkBytes([]byte("foo")),
+	}, "GET")) == fc.addr(1))
+
+	// slot 5061 moved to node 1
+	fc.mu.Lock()
+	fc.slots[5061] = 1
+	fc.mu.Unlock()
+	assert.Must(getProxy(c, r, "bar") == "+"+fc.addr(1))
+
+	// slot 12182 migrating to node 0
+	fc.mu.Lock()
+	fc.asking[12182] = 0
+	fc.mu.Unlock()
+	assert.Must(getProxy(c, r, "foo") == "+"+fc.addr(0))
+	assert.Must(getProxy(c, r, "{foo}.x") == "+"+fc.addr(0))
+	assert.Must(server.stats.moved.Get() != 0 && server.stats.ask.Get() == 2)
+}
+
+func TestRouterMultiKey(t *testing.T) {
+	fc := newFakeCluster(3)
+	defer fc.Close()
+
+	server, errc := newTestClusterServer(fc)
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	var keys = []string{"foo", "bar", "{foo}.x", "baz", "qux"}
+	var b = "*" + strconv.Itoa(len(keys)+1) + "\r\n$4\r\nMGET\r\n"
+	for _, key := range keys {
+		b += "$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n"
+	}
+	b += "*5\r\n$3\r\nDEL\r\n$3\r\nfoo\r\n$3\r\nbar\r\n$3\r\nbaz\r\n$3\r\nqux\r\n"
+	b += "*5\r\n$4\r\nMSET\r\n$3\r\nfoo\r\n$1\r\n1\r\n$3\r\nbar\r\n$1\r\n2\r\n"
+	b += "*3\r\n$4\r\nMSET\r\n$3\r\nfoo\r\n$1\r\n1\r\n"
+	_, err = c.Write([]byte(b))
+	assert.MustNoError(err)
+
+	r := bufio.NewReader(c)
+	readLine := func() string {
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		return strings.TrimSpace(line)
+	}
+	assert.Must(readLine() == "*5")
+	for _, key := range keys {
+		assert.Must(readLine() == "$"+strconv.Itoa(len(key)))
+		assert.Must(readLine() == key)
+	}
+	assert.Must(readLine() == ":4")
+	assert.Must(readLine() == "+OK")
+	assert.Must(readLine() == "+OK")
+}
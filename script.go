@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
+)
+
+/*
+	lua scripts
+	1.EVAL/EVALSHA are routed by slot of KEYS, keys of a script must hash to the same slot
+	2.SCRIPT is broadcast to every master:
+	  LOAD/FLUSH reply the first error or the first reply,
+	  EXISTS replies 1 only if the script exists on every master,
+	  KILL replies +OK if a script is killed on any master
+	3.bodies of EVAL & SCRIPT LOAD are cached by SHA1,
+	  -NOSCRIPT of EVALSHA falls back to EVAL of the cached body
+	  so EVALSHA keeps working after failover or resharding
+	4.SCRIPT FLUSH clears the cache
+ */
+
+const maxScriptCache = 10000
+
+type scriptCache struct {
+	mu      sync.RWMutex
+	scripts map[string][]byte // map[sha1]body
+}
+
+func scriptSHA(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *scriptCache) add(body []byte) {
+	sha := scriptSHA(body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scripts == nil {
+		c.scripts = make(map[string][]byte)
+	}
+	if _, ok := c.scripts[sha]; ok {
+		return
+	}
+	if len(c.scripts) >= maxScriptCache {
+		// 缓存已满, 随机淘汰一个
+		for k := range c.scripts {
+			delete(c.scripts, k)
+			break
+		}
+	}
+	c.scripts[sha] = append([]byte(nil), body...)
+}
+
+func (c *scriptCache) get(sha []byte) []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.scripts[strings.ToLower(string(sha))]
+}
+
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = nil
+}
+
+func (router *Router) dispatchScript(r *Request) error {
+	if r.OpStr == "SCRIPT" {
+		switch strings.ToUpper(string(r.Multi[1].Value)) {
+		case "LOAD":
+			if len(r.Multi) == 3 {
+				router.scripts.add(r.Multi[2].Value)
+			}
+		case "FLUSH":
+			router.scripts.flush()
+		}
+		return router.broadcast(r)
+	}
+
+	if r.OpStr == "EVAL" {
+		router.scripts.add(r.Multi[1].Value)
+	}
+	if router.server.config.BackendMode == BackendModeCluster {
+		keys := getKeys(r.Multi, r.OpStr)
+		for _, key := range keys {
+			if redis.HashSlot(key) != redis.HashSlot(keys[0]) {
+				r.Resp = redis.NewErrorf("CROSSSLOT Keys in request don't hash to the same slot")
+				return nil
+			}
+		}
+	}
+	return router.dispatchAddr(r, router.lookup(r))
+}
+
+/*
+	dispatch a copy of the request to every master, replies are merged by coalesce
+ */
+func (router *Router) broadcast(r *Request) error {
+	addrs := router.masters()
+	if len(addrs) == 0 {
+		return ErrNoMaster
+	}
+	for _, addr := range addrs {
+		sub := NewRequest(r.Multi, r.OpStr)
+		sub.Start = r.Start
+		sub.Database = r.Database
+		if err := router.dispatchAddr(sub, addr); err != nil {
+			sub.Err = err
+		}
+		r.Subs = append(r.Subs, sub)
+	}
+	return nil
+}
+
+/*
+	addresses of all masters, nodes of the slot table in cluster mode
+ */
+func (router *Router) masters() []string {
+	if router.server.config.BackendMode != BackendModeCluster {
+		if addr := router.masterAddr(); addr != "" {
+			return []string{addr}
+		}
+		return nil
+	}
+	var addrs []string
+	var seen = make(map[string]bool)
+	router.mu.RLock()
+	for _, addr := range router.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	router.mu.RUnlock()
+	if len(addrs) == 0 {
+		addrs = append(addrs, router.anyNode())
+	}
+	return addrs
+}
+
+/*
+	merge replies of SCRIPT from all masters
+ */
+func mergeScript(r *Request, resps []*redis.Resp) (*redis.Resp, error) {
+	switch strings.ToUpper(string(r.Multi[1].Value)) {
+	case "EXISTS":
+		var array []*redis.Resp
+		for _, resp := range resps {
+			if resp.IsError() {
+				return resp, nil
+			}
+			if !resp.IsArray() || len(resp.Array) != len(r.Multi)-2 {
+				return nil, errors.Errorf("bad script exists reply, type %s", resp.Type)
+			}
+			if array == nil {
+				array = resp.Array
+				continue
+			}
+			for i, v := range resp.Array {
+				if !bytes.Equal(v.Value, []byte("1")) {
+					array[i] = v
+				}
+			}
+		}
+		return redis.NewArray(array), nil
+	case "KILL":
+		for _, resp := range resps {
+			if !resp.IsError() {
+				return resp, nil
+			}
+		}
+		return resps[0], nil
+	default:
+		for _, resp := range resps {
+			if resp.IsError() {
+				return resp, nil
+			}
+		}
+		return resps[0], nil
+	}
+}
+
+/*
+	reply of EVALSHA, -NOSCRIPT is retried as EVAL of the cached body
+ */
+func (router *Router) handleEvalSha(r *Request) (*redis.Resp, error) {
+	resp, err := router.redirect(r)
+	if err != nil || !resp.IsError() || !bytes.HasPrefix(resp.Value, []byte("NOSCRIPT")) {
+		return resp, err
+	}
+	body := router.scripts.get(r.Multi[1].Value)
+	if body == nil {
+		return resp, nil
+	}
+	multi := append([]*redis.Resp{redis.NewBulkBytes([]byte("EVAL")), redis.NewBulkBytes(body)}, r.Multi[2:]...)
+	redo := NewRequest(multi, "EVAL")
+	redo.Database = r.Database
+	if err := router.dispatchAddr(redo, router.lookup(redo)); err != nil {
+		return nil, err
+	}
+	return router.redirect(redo)
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	fake redis node with scripts for tests:
+	CLUSTER SLOTS -> slots, SCRIPT LOAD/EXISTS/FLUSH, EVAL -> body of the script,
+	EVALSHA -> body of the script or -NOSCRIPT
+ */
+type fakeScriptNode struct {
+	mu      sync.Mutex
+	l       net.Listener
+	slots   string
+	scripts map[string]string
+}
+
+func newFakeScriptNode() *fakeScriptNode {
+	fn := &fakeScriptNode{scripts: make(map[string]string)}
+	fn.l = newFakeServer(func() fakeHandler {
+		return func(c net.Conn, multi []string) string {
+			return fn.handle(multi)
+		}
+	})
+	return fn
+}
+
+func (fn *fakeScriptNode) addr() string {
+	return fn.l.Addr().String()
+}
+
+func (fn *fakeScriptNode) has(sha string) bool {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	_, ok := fn.scripts[sha]
+	return ok
+}
+
+func (fn *fakeScriptNode) flush() {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	fn.scripts = make(map[string]string)
+}
+
+func (fn *fakeScriptNode) handle(multi []string) string {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	switch strings.ToUpper(multi[0]) {
+	case "CLUSTER":
+		return fn.slots
+	case "EVAL":
+		fn.scripts[scriptSHA([]byte(multi[1]))] = multi[1]
+		return fakeBulk(multi[1])
+	case "EVALSHA":
+		if body, ok := fn.scripts[multi[1]]; ok {
+			return fakeBulk(body)
+		}
+		return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+	case "SCRIPT":
+		switch strings.ToUpper(multi[1]) {
+		case "LOAD":
+			sha := scriptSHA([]byte(multi[2]))
+			fn.scripts[sha] = multi[2]
+			return fakeBulk(sha)
+		case "EXISTS":
+			var b = "*" + strconv.Itoa(len(multi)-2) + "\r\n"
+			for _, sha := range multi[2:] {
+				if _, ok := fn.scripts[sha]; ok {
+					b += ":1\r\n"
+				} else {
+					b += ":0\r\n"
+				}
+			}
+			return b
+		case "FLUSH":
+			fn.scripts = make(map[string]string)
+			return "+OK\r\n"
+		}
+	}
+	return "+OK\r\n"
+}
+
+func TestClusterScript(t *testing.T) {
+	nodes := []*fakeScriptNode{newFakeScriptNode(), newFakeScriptNode()}
+	var slots = "*2\r\n"
+	for i, fn := range nodes {
+		defer fn.l.Close()
+		host, port, _ := net.SplitHostPort(fn.addr())
+		slots += "*3\r\n:" + strconv.Itoa(i*8192) + "\r\n:" + strconv.Itoa(i*8192+8191) + "\r\n" +
+			"*2\r\n" + fakeBulk(host) + ":" + port + "\r\n"
+	}
+	for _, fn := range nodes {
+		fn.slots = slots
+	}
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendMode = BackendModeCluster
+	config.BackendAddrs = []string{nodes[0].addr()}
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+	assert.MustNoError(server.router.Refresh())
+
+	c := dialTestConn(server.Addr())
+	defer c.Close()
+
+	// "a" is on node 1, "b" is on node 0
+	body := "return KEYS[1]"
+	sha := scriptSHA([]byte(body))
+	expectString(c.do("SCRIPT", "LOAD", body), sha)
+	assert.Must(nodes[0].has(sha) && nodes[1].has(sha))
+
+	resp := c.do("EVAL", body, "2", "a", "b")
+	assert.Must(resp.IsError() && string(resp.Value) == "CROSSSLOT Keys in request don't hash to the same slot")
+	expectString(c.do("EVAL", body, "2", "{a}1", "{a}2"), body)
+
+	// script lost on node 1 after failover
+	nodes[1].flush()
+	expectArray(c.do("SCRIPT", "EXISTS", sha), "0")
+	expectString(c.do("EVALSHA", sha, "1", "a"), body)
+	assert.Must(nodes[1].has(sha))
+
+	expectString(c.do("SCRIPT", "FLUSH"), "OK")
+	resp = c.do("EVALSHA", sha, "1", "a")
+	assert.Must(resp.IsError() && strings.HasPrefix(string(resp.Value), "NOSCRIPT"))
+}
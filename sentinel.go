@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/redis"
+)
+
+/*
+	sentinel mode: backend_addrs are sentinels monitoring sentinel_master_name
+	1.ask sentinels for the current master, the majority decides
+	2.subscribe +switch-master, query the master again once notified
+	3.repoint requests to the new master and close connections of the old one
+ */
+
+const (
+	sentinelSubscribeTimeout = time.Minute * 15
+	sentinelRetryDelay       = time.Second
+)
+
+var ErrNoMaster = errors.New("master is not discovered by sentinels")
+
+func (router *Router) newSentinel() *redis.Sentinel {
+	config := router.server.config
+	s := redis.NewSentinel(config.SentinelMasterName, config.BackendAuth)
+	s.TLSConfig = router.server.backendTLS
+	s.LogFunc = func(format string, args ...interface{}) {
+		log.Warnf(format, args...)
+	}
+	s.ErrFunc = func(err error, format string, args ...interface{}) {
+		log.WarnErrorf(err, format, args...)
+	}
+	return s
+}
+
+func (router *Router) loopSentinel() {
+	config := router.server.config
+	s := router.newSentinel()
+	go func() {
+		<-router.exit.C
+		s.Cancel()
+	}()
+
+	timeout := config.BackendDialTimeout.Get()
+	for !s.IsCanceled() {
+		addr, err := s.Master(config.SentinelMasterName, timeout, config.BackendAddrs...)
+		if err != nil {
+			log.WarnErrorf(err, "sentinel get master %s failed", config.SentinelMasterName)
+		} else {
+			router.switchMaster(addr)
+		}
+
+		// 阻塞直到+switch-master, 超时或失去多数派后重新查询
+		var start = time.Now()
+		if !s.Subscribe(sentinelSubscribeTimeout, nil, config.BackendAddrs...) {
+			if d := sentinelRetryDelay - time.Since(start); d > 0 && !s.IsCanceled() {
+				select {
+				case <-router.exit.C:
+				case <-time.After(d):
+				}
+			}
+		}
+	}
+}
+
+/*
+	ask sentinels for the current master once
+ */
+func (router *Router) refreshMaster() error {
+	config := router.server.config
+	addr, err := router.newSentinel().Master(config.SentinelMasterName, config.BackendDialTimeout.Get(), config.BackendAddrs...)
+	if err != nil {
+		return err
+	}
+	router.switchMaster(addr)
+	return nil
+}
+
+/*
+	repoint requests to master addr, stale connections of the old master are closed
+ */
+func (router *Router) switchMaster(addr string) {
+	router.mu.Lock()
+	old := router.master
+	router.master = addr
+	router.mu.Unlock()
+
+	if old == addr {
+		return
+	}
+	log.Warnf("sentinel switch master %s from [%s] to [%s]", router.server.config.SentinelMasterName, old, addr)
+	if old != "" {
+		router.server.manager.remove(old)
+	}
+}
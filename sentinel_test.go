@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	fake sentinel for tests:
+	SENTINEL get-master-addr-by-name / master -> current master,
+	SUBSCRIBE -> +switch-master is published by failover
+ */
+type fakeSentinel struct {
+	net.Listener
+
+	mu     sync.Mutex
+	name   string
+	master string
+	epoch  int
+	subs   []net.Conn
+}
+
+func newFakeSentinel(name, master string) *fakeSentinel {
+	fs := &fakeSentinel{name: name, master: master}
+	fs.Listener = newFakeServer(func() fakeHandler {
+		return fs.handle
+	})
+	return fs
+}
+
+func (fs *fakeSentinel) handle(c net.Conn, multi []string) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	host, port, _ := net.SplitHostPort(fs.master)
+	switch strings.ToUpper(multi[0]) {
+	case "SUBSCRIBE":
+		// 先回复订阅再加入 subs，保证 failover 的消息在回复之后
+		c.Write([]byte("*3\r\n" + fakeBulk("subscribe") + fakeBulk(multi[1]) + ":1\r\n"))
+		fs.subs = append(fs.subs, c)
+		return ""
+	case "SENTINEL":
+		if multi[2] != fs.name {
+			return "*-1\r\n"
+		} else if multi[1] == "get-master-addr-by-name" {
+			return "*2\r\n" + fakeBulk(host) + fakeBulk(port)
+		}
+		return "*8\r\n" + fakeBulk("name") + fakeBulk(fs.name) +
+			fakeBulk("ip") + fakeBulk(host) + fakeBulk("port") + fakeBulk(port) +
+			fakeBulk("config-epoch") + fakeBulk(strconv.Itoa(fs.epoch))
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (fs *fakeSentinel) failover(master string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(fs.master)
+	newHost, newPort, _ := net.SplitHostPort(master)
+	fs.master = master
+	fs.epoch++
+	payload := strings.Join([]string{fs.name, oldHost, oldPort, newHost, newPort}, " ")
+	for _, c := range fs.subs {
+		c.Write([]byte("*3\r\n" + fakeBulk("message") + fakeBulk("+switch-master") + fakeBulk(payload)))
+	}
+}
+
+func waitMaster(server *Server, addr string) {
+	for i := 0; i < 200; i++ {
+		server.router.mu.RLock()
+		master := server.router.master
+		server.router.mu.RUnlock()
+		if master == addr {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Must(false)
+}
+
+func TestSentinelFailover(t *testing.T) {
+	backend1 := newFakeRedis()
+	defer backend1.Close()
+	backend2 := newFakeRedis()
+	defer backend2.Close()
+
+	fs := newFakeSentinel("mymaster", backend1.Addr().String())
+	defer fs.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendMode = BackendModeSentinel
+	config.BackendAddrs = []string{fs.Addr().String()}
+	config.SentinelMasterName = "mymaster"
+	server, errc := startTestServer(config)
+
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	waitMaster(server, backend1.Addr().String())
+	pingProxy(server.Addr())
+	assert.Must(server.manager.Stats()[backend1.Addr().String()] != 0)
+
+	fs.failover(backend2.Addr().String())
+	waitMaster(server, backend2.Addr().String())
+	pingProxy(server.Addr())
+	stats := server.manager.Stats()
+	assert.Must(stats[backend1.Addr().String()] == 0 && stats[backend2.Addr().String()] != 0)
+}
@@ -1,123 +1,254 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"net"
-	"log"
-	"bufio"
-	"bytes"
-	"strings"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+	"SSAWPROXY/redisProxy/utils/math2"
+)
+
+var ErrClosedServer = errors.New("use of closed server")
+
+const (
+	acceptMinDelay = time.Millisecond * 5
+	acceptMaxDelay = time.Second
 )
 
 /*
 	tcp server proxy lots of clients
  */
 type Server struct {
-	clients		[]Client
-	address		string
+	mu		sync.Mutex
+	clients		ClientManager
+	config		*Config
+	manager		*RedisManager	// backend connection pool
+	router		*Router		// choose backend for requests
+	acl		*ACL		// users and permissions of client sessions
+	filter		*commandFilter	// blocked commands of client sessions
+	stats		serverStats
+	slowlog		*slowlog
+	accessLog	*accessLog	// nil if access_log_file is empty
+	tls		*tlsLoader	// nil if TLS is disabled
+	backendTLS	*tls.Config	// TLS of backend connections, nil if disabled
+	admin		*adminServer	// http admin listener, nil if admin_addr is empty
+
+	listener	net.Listener
+	closed		bool
+}
+
+/*
+	create server from a validated config
+ */
+func NewServer(config *Config) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	acl, err := NewACL(config.SessionUsers, config.SessionDefaultRules)
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		config:config,
+		acl:acl,
+		filter:newCommandFilter(),
+		slowlog:newSlowlog(config),
+	}
+	server.stats.start = time.Now()
+	if server.backendTLS, err = newBackendTLSConfig(config); err != nil {
+		return nil, err
+	}
+	if config.TLSCertFile != "" {
+		if server.tls, err = newTLSLoader(config); err != nil {
+			return nil, err
+		}
+	}
+	if config.AccessLogFile != "" {
+		if server.accessLog, err = newAccessLog(config); err != nil {
+			return nil, err
+		}
+	}
+	server.manager = NewRedisManager(server)
+	server.router = NewRouter(server)
+	return server, nil
 }
 
 /*
 	handlerConnection(conn)
  */
 func (server *Server) handleConnection(conn net.Conn){
-	//引入命令过滤器
-	var filter filter
-	//引入工具类
-	var utils utils
-	// 命令过滤器初始化
-	f := filter.filter()
-
-	client := &Client{
-		conn:conn,
-		server:server,
-		reader:bufio.NewReaderSize(conn, 1024),
-		writer:bufio.NewWriterSize(conn, 1024),
-		bufferSize:1024,
-	}
-
-	// 创建redis单机连接
-	//redis://d1Iuw3qlDBntyx1w@10.100.150.31:6011
-	rc, _ := net.Dial("tcp", "xxxx")
-	rConn := &redisConn{
-		conn: rc,
-		server: server,
-		br: bufio.NewReader(rc),
-		bw: bufio.NewWriter(rc),
-		password: "xxxx",
-	}
-	// 兼容单机模式加密
-	rConn.SendBytes(utils.auth(rConn.password))
-	rConn.Receive()
+	config := server.config
+	client := NewClient(conn, config)
+	client.server = server
+	client.id = server.stats.sessions.Incr()
+	// 以当前acl为准, reload增删用户后新的session随之生效
+	client.authorized = !server.acl.HasUsers()
+
+	server.mu.Lock()
+	if server.closed {
+		server.mu.Unlock()
+		client.Close()
+		return
+	}
+	server.clients.add(client)
+	server.mu.Unlock()
 
 	go func(){
-		for {
-			// 从客户端接收数据
-			message, err := client.readAll()
-			if err != nil {
-				log.Fatal(err)
-			}
-			if len(message) != 0 && string(message[0]) == "*" {
-				charset := "\r\n"
-				index := bytes.IndexAny(message[8:], charset)
-				cmd := string(message[8:8 + index])
-				_, ok := f[strings.ToUpper(cmd)]
-				// 过滤不支持的命令
-				if ok {
-					// 返回错误信息
-					res := []byte("-ERR the command not support\r\n")
-					client.SendBytes(res)
-				} else {
-					// 向redis发送数据
-					err = rConn.SendBytes(message)
-					if err != nil {
-						log.Fatal(err)
-					}
-					// 从redis接收数据
-					res, _ := rConn.Receive()
-
-					// 兼容集群模式
-					// 创建新的redis连接
-					if utils.cluster(res) != ""{
-						rc, _ := net.Dial("tcp", strings.Fields(string(res))[2])
-						redisConn := & redisConn{
-							conn: rc,
-							server: server,
-							br: bufio.NewReader(rc),
-							bw: bufio.NewWriter(rc),
-						}
-						err = redisConn.SendBytes(message)
-						if err != nil {
-							log.Fatal(err)
-						}
-						res, _ := redisConn.Receive()
-						client.SendBytes(res)
-						// 关闭redis连接
-						rc.Close()
-						redisConn.Close()
-					}else {
-						client.SendBytes(res)
-					}
-				}
-			}
+		defer server.release(client)
+		// 单个client出错只关闭该client, 不影响其他client
+		if err := client.serve(server.filter, config.SessionMaxPipeline); err != nil {
+			log.WarnErrorf(err, "client [%s] closed with error", client.RemoteAddr())
 		}
 	}()
 }
 
 /*
-	listen tcp server
+	reload users and acl rules, sessions keep their user and follow the new rules
  */
-func (server *Server) Listen() {
+func (server *Server) ReloadACL(users []*UserConfig, defaultRules string) error {
+	return server.acl.Reload(users, defaultRules)
+}
 
-	listener, err := net.Listen("tcp", server.address)
+/*
+	close client and its backend connection after the client loop exits
+ */
+func (server *Server) release(client *Client) {
+	client.Close()
+	server.clients.remove(client)
+}
+
+/*
+	listen tcp server, block until Close is called
+	TLS is terminated if tls_cert_file is set,
+	the admin listener is started if admin_addr is set
+ */
+func (server *Server) Listen() error {
+	server.mu.Lock()
+	if server.closed {
+		server.mu.Unlock()
+		return ErrClosedServer
+	}
+	listener, err := net.Listen(server.config.ProtoType, server.config.ProxyAddr)
 	if err != nil{
-		log.Fatal("Error starting TCP server")
+		server.mu.Unlock()
+		return errors.Trace(err)
+	}
+	if server.tls != nil {
+		// 握手在client读取第一个请求时进行
+		listener = tls.NewListener(listener, server.tls.TLSConfig())
+	}
+	if server.config.AdminAddr != "" {
+		if server.admin, err = newAdminServer(server); err != nil {
+			listener.Close()
+			server.mu.Unlock()
+			return err
+		}
 	}
+	server.listener = listener
+	server.mu.Unlock()
+
 	defer listener.Close()
+	var delay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Fatal(err)
+			if server.IsClosed() {
+				return nil
+			}
+			// accept出错后退避重试, 如: too many open files
+			if delay == 0 {
+				delay = acceptMinDelay
+			} else {
+				delay = math2.MinDuration(delay*2, acceptMaxDelay)
+			}
+			log.WarnErrorf(err, "accept failed, retrying in %v", delay)
+			time.Sleep(delay)
+			continue
 		}
+		delay = 0
 		go server.handleConnection(conn)	// 调用处理方法
 	}
 }
+
+/*
+	listen address of server, empty before Listen is called
+ */
+func (server *Server) Addr() string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.listener == nil {
+		return ""
+	}
+	return server.listener.Addr().String()
+}
+
+/*
+	listen address of admin listener, empty if it is disabled or before Listen is called
+ */
+func (server *Server) AdminAddr() string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.admin == nil {
+		return ""
+	}
+	return server.admin.Addr()
+}
+
+func (server *Server) IsClosed() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.closed
+}
+
+/*
+	graceful shutdown:
+	1.stop accepting new clients
+	2.let every client finish its in-flight request
+	3.close client and backend connections
+	return error if clients are not drained within shutdown_timeout
+ */
+func (server *Server) Close() error {
+	server.mu.Lock()
+	if server.closed {
+		server.mu.Unlock()
+		return nil
+	}
+	server.closed = true
+	if server.listener != nil {
+		server.listener.Close()
+	}
+	if server.admin != nil {
+		server.admin.Close()
+	}
+	server.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		server.clients.drain()
+		close(done)
+	}()
+
+	defer server.manager.Close()
+	defer server.router.Close()
+	if server.tls != nil {
+		defer server.tls.Close()
+	}
+	if server.accessLog != nil {
+		defer server.accessLog.Close()
+	}
+
+	timeout := server.config.ShutdownTimeout.Get()
+	if timeout == 0 {
+		<-done
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.Errorf("shutdown timeout, %d clients still alive", server.clients.Len())
+	}
+}
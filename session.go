@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+)
+
+/*
+	session-local commands replied by proxy
+	1.PING [message] -> +PONG or the message
+	2.ECHO message -> the message
+	3.SELECT index: database of the session, backend connections are checked out
+	  with the database selected, cluster mode supports database 0 only,
+	  not allowed while WATCH pins a backend connection
+	4.SLOWLOG of proxy, see slowlog.go
+	QUIT closes the client only, see client.loopReader
+	state of sessions is listed by the admin api, see sessionInfo
+ */
+
+func (client *Client) handleLocal(r *Request) *redis.Resp {
+	switch r.OpStr {
+	case "PING":
+		if len(r.Multi) > 2 {
+			return redis.NewErrorf("ERR wrong number of arguments for 'ping' command")
+		}
+		if len(r.Multi) == 1 {
+			return redis.NewString([]byte("PONG"))
+		}
+		return redis.NewBulkBytes(r.Multi[1].Value)
+	case "ECHO":
+		return redis.NewBulkBytes(r.Multi[1].Value)
+	case "SELECT":
+		return client.handleSelect(r)
+	case "SLOWLOG":
+		return client.handleSlowlog(r)
+	}
+	return nil
+}
+
+func (client *Client) handleSelect(r *Request) *redis.Resp {
+	config := client.server.config
+	database, err := strconv.Atoi(string(r.Multi[1].Value))
+	if err != nil {
+		return redis.NewErrorf("ERR value is not an integer or out of range")
+	}
+	if config.BackendMode == BackendModeCluster && database != 0 {
+		return redis.NewErrorf("ERR SELECT is not allowed in cluster mode")
+	}
+	if database < 0 || database >= config.BackendDatabases {
+		return redis.NewErrorf("ERR DB index is out of range")
+	}
+	if client.txn.conn != nil {
+		// WATCH固定的连接已选择了原数据库, EXEC会在原数据库执行
+		return redis.NewErrorf("ERR SELECT is not allowed after WATCH, UNWATCH first")
+	}
+	client.mu.Lock()
+	client.database = database
+	client.mu.Unlock()
+	return redis.NewString([]byte("OK"))
+}
+
+/*
+	state of a session, fields follow CLIENT LIST of redis, age & idle are in seconds
+ */
+type sessionInfo struct {
+	Id         int64  `json:"id"`
+	Addr       string `json:"addr"`
+	User       string `json:"user"`
+	Database   int    `json:"db"`
+	Age        int64  `json:"age"`
+	Idle       int64  `json:"idle"`
+	Ops        int64  `json:"cmds"`
+	Subscribed bool   `json:"sub"`
+}
+
+func (client *Client) sessionInfo() *sessionInfo {
+	client.mu.Lock()
+	user, database := client.user, client.database
+	client.mu.Unlock()
+	return &sessionInfo{
+		Id:         client.id,
+		Addr:       client.RemoteAddr(),
+		User:       user,
+		Database:   database,
+		Age:        int64(time.Since(client.created).Seconds()),
+		Idle:       int64(time.Since(time.Unix(0, client.active.Get())).Seconds()),
+		Ops:        client.ops.Get(),
+		Subscribed: client.subscribed.Get(),
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	fake redis with databases for tests:
+	SELECT index -> +OK, DBNAME -> +db<index>, INFO -> server & keyspace sections
+ */
+func newFakeDatabases() net.Listener {
+	return newFakeServer(func() fakeHandler {
+		var database = "0"
+		return func(c net.Conn, multi []string) string {
+			switch strings.ToUpper(multi[0]) {
+			case "SELECT":
+				database = multi[1]
+				return "+OK\r\n"
+			case "DBNAME":
+				return "+db" + database + "\r\n"
+			case "INFO":
+				return fakeBulk("# Server\r\nredis_version:6.2.0\r\n\r\n# Keyspace\r\ndb0:keys=2,expires=0\r\n")
+			}
+			return "-ERR unknown command '" + multi[0] + "'\r\n"
+		}
+	})
+}
+
+func TestAggregateInfo(t *testing.T) {
+	info := aggregateInfo([]*redis.Resp{
+		redis.NewBulkBytes([]byte("# Server\r\nredis_version:6.2.0\r\nrole:master\r\ntcp_port:7000\r\nuptime_in_seconds:100\r\n\r\n" +
+			"# Clients\r\nconnected_clients:2\r\n\r\n" +
+			"# Commandstats\r\ncmdstat_get:calls=2,usec=10,usec_per_call=5.00\r\n\r\n" +
+			"# Keyspace\r\ndb0:keys=2,expires=0,avg_ttl=10\r\n")),
+		redis.NewBulkBytes([]byte("# Server\r\nredis_version:6.2.1\r\nrole:master\r\ntcp_port:7001\r\nuptime_in_seconds:200\r\n\r\n" +
+			"# Clients\r\nconnected_clients:3\r\n\r\n" +
+			"# Commandstats\r\ncmdstat_get:calls=3,usec=20,usec_per_call=6.67\r\n\r\n" +
+			"# Keyspace\r\ndb0:keys=3,expires=1,avg_ttl=20\r\n")),
+	})
+	assert.Must(string(info) == "# Server\r\nredis_version:6.2.0\r\nrole:master\r\ntcp_port:7000\r\nuptime_in_seconds:100\r\n\r\n"+
+		"# Clients\r\nconnected_clients:5\r\n\r\n"+
+		"# Commandstats\r\ncmdstat_get:calls=5,usec=30,usec_per_call=6.00\r\n\r\n"+
+		"# Keyspace\r\ndb0:keys=5,expires=1,avg_ttl=10\r\n")
+}
+
+func TestClientLocalCommands(t *testing.T) {
+	backend := newFakeDatabases()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.SessionDefaultRules = "+@all"
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c1 := dialTestConn(server.Addr())
+	defer c1.Close()
+	c2 := dialTestConn(server.Addr())
+	defer c2.Close()
+
+	expectString(c1.do("PING"), "PONG")
+	expectString(c1.do("PING", "hello"), "hello")
+	expectString(c1.do("ECHO", "hello"), "hello")
+
+	// database of a session doesn't affect others
+	expectString(c1.do("SELECT", "3"), "OK")
+	expectString(c1.do("DBNAME"), "db3")
+	expectString(c2.do("DBNAME"), "db0")
+	expectString(c1.do("SELECT", "16"), "ERR DB index is out of range")
+	expectString(c1.do("SELECT", "x"), "ERR value is not an integer or out of range")
+	expectString(c1.do("MULTI"), "OK")
+	expectString(c1.do("SELECT", "1"), "ERR Command not allowed inside a transaction")
+	expectString(c1.do("DISCARD"), "OK")
+	expectString(c1.do("DBNAME"), "db3")
+
+	info := string(c1.do("INFO", "sessions").Value)
+	assert.Must(info == "# Sessions\r\nconnected_sessions:2\r\ntotal_sessions_received:2\r\n\r\n")
+	info = string(c1.do("INFO").Value)
+	for _, s := range []string{"# Proxy\r\n", "# Ops\r\n", "# Backends\r\n", "redis_version:6.2.0\r\n"} {
+		assert.Must(strings.Contains(info, s))
+	}
+	info = string(c1.do("INFO", "keyspace").Value)
+	assert.Must(!strings.Contains(info, "# Proxy") && strings.Contains(info, "db0:keys=2,expires=0"))
+
+	// QUIT closes the client only
+	expectString(c1.do("QUIT"), "OK")
+	_, err := c1.d.Decode()
+	assert.Must(err != nil)
+	expectString(c2.do("DBNAME"), "db0")
+	assert.Must(len(c2.do("INFO", "ops").Value) != 0)
+}
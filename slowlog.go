@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/log"
+)
+
+/*
+	slow log of proxy
+	1.requests are timed from decode to reply flush, the backend part is from dispatch to the backend reply
+	2.requests slower than slowlog_log_slower_than are kept in a ring buffer of slowlog_max_len
+	3.SLOWLOG GET [count] / LEN / RESET are replied by proxy, entries are also listed by the admin api
+	4.entries are written by utils/log if slowlog_log is true
+	args are truncated like redis: at most 32 args and 128 bytes per arg,
+	passwords of AUTH and HELLO ... AUTH are redacted
+ */
+
+const (
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	Id       int64    `json:"id"`
+	Time     int64    `json:"time"`     // unix time of the reply
+	Duration int64    `json:"duration"` // microseconds from decode to reply flush
+	Backend  int64    `json:"backend"`  // microseconds spent on backend
+	Proxy    int64    `json:"proxy"`    // duration - backend
+	Args     []string `json:"args"`
+	Client   string   `json:"client"`
+	Addr     string   `json:"addr"` // backend address, empty if replied by proxy
+}
+
+type slowlog struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry // ring buffer
+	next    int             // index of the next entry
+	full    bool
+	lastId  int64
+
+	slowerThan time.Duration
+	log        bool
+}
+
+func newSlowlog(config *Config) *slowlog {
+	return &slowlog{
+		entries:    make([]*slowlogEntry, config.SlowlogMaxLen),
+		slowerThan: config.SlowlogLogSlowerThan.Get(),
+		log:        config.SlowlogLog,
+	}
+}
+
+/*
+	record a replied request of the client if it is slow
+ */
+func (s *slowlog) record(client *Client, r *Request) {
+	if s.slowerThan == 0 || len(s.entries) == 0 {
+		return
+	}
+	d := time.Since(r.Start)
+	if d < s.slowerThan {
+		return
+	}
+	addr, backend := requestBackend(r)
+	e := &slowlogEntry{
+		Time:     time.Now().Unix(),
+		Duration: d.Microseconds(),
+		Backend:  backend.Microseconds(),
+		Proxy:    (d - backend).Microseconds(),
+		Args:     slowlogArgs(r.Multi, r.OpStr),
+		Client:   client.RemoteAddr(),
+		Addr:     addr,
+	}
+	s.mu.Lock()
+	s.lastId++
+	e.Id = s.lastId
+	s.entries[s.next] = e
+	if s.next++; s.next == len(s.entries) {
+		s.next, s.full = 0, true
+	}
+	s.mu.Unlock()
+
+	if s.log {
+		log.Warnf("slowlog: [%d] client [%s] backend [%s] %v, proxy %v, backend %v, %s",
+			e.Id, e.Client, e.Addr, d, d-backend, backend, strings.Join(e.Args, " "))
+	}
+}
+
+/*
+	latest count entries, newest first, count < 0 means all
+ */
+func (s *slowlog) Get(count int) []*slowlogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.len()
+	if count < 0 || count > n {
+		count = n
+	}
+	list := make([]*slowlogEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		list = append(list, s.entries[(s.next-i+len(s.entries))%len(s.entries)])
+	}
+	return list
+}
+
+func (s *slowlog) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.len()
+}
+
+func (s *slowlog) len() int {
+	if s.full {
+		return len(s.entries)
+	}
+	return s.next
+}
+
+func (s *slowlog) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.entries {
+		s.entries[i] = nil
+	}
+	s.next, s.full = 0, false
+}
+
+/*
+	backend addresses & time of a request,
+	split requests take the slowest sub request, addresses are joined by ","
+ */
+func requestBackend(r *Request) (string, time.Duration) {
+	if r.Subs == nil {
+		return r.Addr, r.Backend
+	}
+	var addrs []string
+	var seen = make(map[string]bool)
+	var backend time.Duration
+	for _, sub := range r.Subs {
+		if sub.Addr != "" && !seen[sub.Addr] {
+			seen[sub.Addr] = true
+			addrs = append(addrs, sub.Addr)
+		}
+		if sub.Backend > backend {
+			backend = sub.Backend
+		}
+	}
+	return strings.Join(addrs, ","), backend
+}
+
+func slowlogArgs(multi []*redis.Resp, opstr string) []string {
+	argc := len(multi)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	args := make([]string, 0, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowlogMaxArgc-1 && len(multi) > slowlogMaxArgc {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(multi)-slowlogMaxArgc+1))
+			break
+		}
+		args = append(args, truncateArg(multi[i].Value))
+	}
+	redactArgs(args, opstr)
+	return args
+}
+
+/*
+	replace credentials in args as redis does:
+	AUTH [username] password & HELLO [protover [AUTH username password] [SETNAME clientname]]
+ */
+func redactArgs(args []string, opstr string) {
+	switch opstr {
+	case "AUTH":
+		for i := 1; i < len(args); i++ {
+			args[i] = "(redacted)"
+		}
+	case "HELLO":
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "SETNAME":
+				i++
+			case "AUTH":
+				for j := i + 1; j <= i+2 && j < len(args); j++ {
+					args[j] = "(redacted)"
+				}
+				i += 2
+			}
+		}
+	}
+}
+
+func truncateArg(v []byte) string {
+	if len(v) > slowlogMaxArgLen {
+		return fmt.Sprintf("%s... (%d more bytes)", v[:slowlogMaxArgLen], len(v)-slowlogMaxArgLen)
+	}
+	return string(v)
+}
+
+/*
+	SLOWLOG GET [count] / LEN / RESET
+	entries are replied as redis does: id, time, duration, args, client addr, client name,
+	followed by backend addr & backend duration
+ */
+func (client *Client) handleSlowlog(r *Request) *redis.Resp {
+	s := client.server.slowlog
+	switch sub := strings.ToUpper(string(r.Multi[1].Value)); {
+	case sub == "GET" && len(r.Multi) <= 3:
+		count := 10
+		if len(r.Multi) == 3 {
+			n, err := strconv.Atoi(string(r.Multi[2].Value))
+			if err != nil || n < -1 {
+				return redis.NewErrorf("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		var array []*redis.Resp
+		for _, e := range s.Get(count) {
+			args := make([]*redis.Resp, len(e.Args))
+			for i, arg := range e.Args {
+				args[i] = redis.NewBulkBytes([]byte(arg))
+			}
+			array = append(array, redis.NewArray([]*redis.Resp{
+				redis.NewInt([]byte(strconv.FormatInt(e.Id, 10))),
+				redis.NewInt([]byte(strconv.FormatInt(e.Time, 10))),
+				redis.NewInt([]byte(strconv.FormatInt(e.Duration, 10))),
+				redis.NewArray(args),
+				redis.NewBulkBytes([]byte(e.Client)),
+				redis.NewBulkBytes([]byte("")),
+				redis.NewBulkBytes([]byte(e.Addr)),
+				redis.NewInt([]byte(strconv.FormatInt(e.Backend, 10))),
+			}))
+		}
+		return redis.NewArray(array)
+	case sub == "LEN" && len(r.Multi) == 2:
+		return redis.NewInt([]byte(strconv.Itoa(s.Len())))
+	case sub == "RESET" && len(r.Multi) == 2:
+		s.Reset()
+		return redis.NewString([]byte("OK"))
+	}
+	return redis.NewErrorf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SLOWLOG HELP.", r.Multi[1].Value)
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/assert"
+	"SSAWPROXY/redisProxy/utils/rpc"
+)
+
+func TestSlowlogRing(t *testing.T) {
+	config := NewDefaultConfig()
+	config.SlowlogLogSlowerThan.Set(time.Nanosecond)
+	config.SlowlogMaxLen = 3
+	s := newSlowlog(config)
+
+	c, _ := net.Pipe()
+	defer c.Close()
+	client := NewClient(c, config)
+	for i := 0; i < 5; i++ {
+		r := NewRequest([]*redis.Resp{redis.NewBulkBytes([]byte("GET")), redis.NewBulkBytes([]byte(strconv.Itoa(i)))}, "GET")
+		r.Addr, r.Backend = "127.0.0.1:6379", time.Nanosecond
+		s.record(client, r)
+	}
+	assert.Must(s.Len() == 3)
+	list := s.Get(-1)
+	assert.Must(len(list) == 3 && list[0].Id == 5 && list[2].Id == 3)
+	assert.Must(list[0].Args[1] == "4" && list[0].Addr == "127.0.0.1:6379")
+	assert.Must(len(s.Get(1)) == 1 && s.Get(1)[0].Id == 5)
+	s.Reset()
+	assert.Must(s.Len() == 0 && len(s.Get(-1)) == 0)
+
+	var multi []*redis.Resp
+	for i := 0; i < 40; i++ {
+		multi = append(multi, redis.NewBulkBytes([]byte(strings.Repeat("x", 200))))
+	}
+	args := slowlogArgs(multi, "UNKNOWN")
+	assert.Must(len(args) == 32 && args[31] == "... (9 more arguments)")
+	assert.Must(args[0] == strings.Repeat("x", 128)+"... (72 more bytes)")
+
+	var tests = []struct {
+		args, expect string
+	}{
+		{"AUTH secret", "AUTH (redacted)"},
+		{"AUTH user secret", "AUTH (redacted) (redacted)"},
+		{"HELLO 2 AUTH user secret SETNAME name", "HELLO 2 AUTH (redacted) (redacted) SETNAME name"},
+		{"HELLO 2 SETNAME auth", "HELLO 2 SETNAME auth"},
+		{"GET auth", "GET auth"},
+	}
+	for _, test := range tests {
+		r := newTestRequest(strings.Fields(test.args)...)
+		assert.Must(strings.Join(slowlogArgs(r.Multi, r.OpStr), " ") == test.expect)
+	}
+}
+
+func TestSlowlog(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.AdminAddr = "127.0.0.1:0"
+	config.AdminAuth = "secret"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.SessionDefaultRules = "+@all"
+	config.SlowlogLogSlowerThan.Set(time.Millisecond * 50)
+	config.SlowlogLog = true
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c := dialTestConn(server.Addr())
+	defer c.Close()
+
+	expectString(c.do("SET", "k", "v"), "OK")
+	expectString(c.do("SLEEP"), "OK")
+	expectString(c.do("SLOWLOG", "LEN"), "1")
+
+	resp := c.do("SLOWLOG", "GET")
+	assert.Must(len(resp.Array) == 1)
+	e := resp.Array[0].Array
+	assert.Must(len(e) == 8 && string(e[0].Value) == "1")
+	assert.Must(len(e[3].Array) == 1 && string(e[3].Array[0].Value) == "SLEEP")
+	assert.Must(string(e[4].Value) == c.LocalAddr().String())
+	assert.Must(string(e[6].Value) == backend.Addr().String())
+	duration, _ := strconv.ParseInt(string(e[2].Value), 10, 64)
+	backendTime, _ := strconv.ParseInt(string(e[7].Value), 10, 64)
+	assert.Must(backendTime >= 100000 && duration >= backendTime)
+
+	var entries []*slowlogEntry
+	assert.MustNoError(rpc.ApiGetJson(rpc.EncodeURL(server.AdminAddr(), "/api/slowlog")+"?xauth="+AdminXAuth("secret"), &entries))
+	assert.Must(len(entries) == 1 && entries[0].Addr == backend.Addr().String() && entries[0].Backend == backendTime)
+
+	assert.Must(c.do("SLOWLOG", "GET", "x").Type == redis.TypeError)
+	expectString(c.do("SLOWLOG", "RESET"), "OK")
+	expectString(c.do("SLOWLOG", "LEN"), "0")
+}
@@ -1,28 +1,394 @@
 package proxy
 
 import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
-	"log"
+	"time"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/assert"
 )
 
-const address  = "xxxx"
+/*
+	handler of requests on a connection of a fake server, the reply is written to c if it is not empty,
+	handlers may also write to c or other connections by themselves, or close c
+ */
+type fakeHandler func(c net.Conn, multi []string) string
+
+/*
+	fake server for tests, newHandler is called for every accepted connection,
+	so state of the connection can be kept by the handler
+ */
+func newFakeServer(newHandler func() fakeHandler) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	serveFake(l, newHandler)
+	return l
+}
+
+func serveFake(l net.Listener, newHandler func() fakeHandler) {
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn, handle fakeHandler) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					multi, err := readFakeRequest(r)
+					if err != nil {
+						return
+					}
+					if reply := handle(c, multi); reply != "" {
+						c.Write([]byte(reply))
+					}
+				}
+			}(c, newHandler())
+		}
+	}()
+}
+
+func readFakeRequest(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	multi := make([]string, n)
+	for i := range multi {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		if multi[i], err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		multi[i] = strings.TrimSpace(multi[i])
+	}
+	return multi, nil
+}
+
+func fakeBulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+/*
+	fake redis server for tests:
+	PING -> +PONG, GET n -> bulk bytes of length n,
+	CRASH -> close connection without reply, SLEEP -> +OK after 100ms,
+	others -> +OK
+ */
+func newFakeRedis() net.Listener {
+	return newFakeServer(fakeRedis)
+}
+
+func fakeRedis() fakeHandler {
+	return func(c net.Conn, multi []string) string {
+		switch strings.ToUpper(multi[0]) {
+		case "PING":
+			return "+PONG\r\n"
+		case "CRASH":
+			c.Close()
+			return ""
+		case "SLEEP":
+			time.Sleep(time.Millisecond * 100)
+			return "+OK\r\n"
+		case "GET":
+			n, _ := strconv.Atoi(multi[1])
+			return fakeBulk(strings.Repeat("x", n))
+		}
+		return "+OK\r\n"
+	}
+}
+
+func encodeTestRequest(args ...string) []byte {
+	var b = "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		b += fakeBulk(arg)
+	}
+	return []byte(b)
+}
+
+/*
+	client session of proxy for tests
+ */
+type testConn struct {
+	net.Conn
+	d *redis.Decoder
+}
+
+func dialTestConn(addr string) *testConn {
+	c, err := net.Dial("tcp", addr)
+	assert.MustNoError(err)
+	return &testConn{Conn: c, d: redis.NewDecoder(c)}
+}
+
+/*
+	send a request and decode its reply
+ */
+func (c *testConn) do(args ...string) *redis.Resp {
+	_, err := c.Write(encodeTestRequest(args...))
+	assert.MustNoError(err)
+	return c.decode()
+}
+
+func (c *testConn) decode() *redis.Resp {
+	resp, err := c.d.Decode()
+	assert.MustNoError(err)
+	return resp
+}
+
+func expectString(resp *redis.Resp, s string) {
+	assert.Must(string(resp.Value) == s)
+}
+
+func expectArray(resp *redis.Resp, values ...string) {
+	assert.Must(resp.IsArray() && len(resp.Array) == len(values))
+	for i, v := range values {
+		assert.Must(string(resp.Array[i].Value) == v)
+	}
+}
+
+func newTestServer(backend string) (*Server, chan error) {
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend}
+	return startTestServer(config)
+}
+
+func startTestServer(config *Config) (*Server, chan error) {
+	server, err := NewServer(config)
+	assert.MustNoError(err)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Listen()
+	}()
+	for server.Addr() == "" {
+		time.Sleep(time.Millisecond * 10)
+	}
+	return server, errc
+}
+
+func TestServerClose(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+
+	_, err = c.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	assert.MustNoError(err)
+	r := bufio.NewReader(c)
+	line, err := r.ReadString('\n')
+	assert.MustNoError(err)
+	assert.Must(line == "+PONG\r\n")
+
+	assert.MustNoError(server.Close())
+	assert.MustNoError(<-errc)
+	assert.Must(server.clients.Len() == 0)
+
+	// client connection is closed by proxy
+	_, err = r.ReadString('\n')
+	assert.Must(err == io.EOF)
+
+	// no more new clients
+	_, err = net.DialTimeout("tcp", server.Addr(), time.Second)
+	assert.Must(err != nil)
+}
+
+func TestClientRequestFraming(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+	r := bufio.NewReader(c)
+
+	var requests = []string{
+		// pipelined
+		"*1\r\n$4\r\nPING\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n",
+		// partial frames
+		"*1\r\n$4\r\nPI", "NG\r\n",
+		// inline command
+		"PING\r\n",
+		// long command & command denied by default acl
+		"*12\r\n$4\r\nMSET\r\n" + strings.Repeat("$1\r\nx\r\n", 11),
+		"*2\r\n$4\r\nkeys\r\n$1\r\n*\r\n",
+	}
+	for _, s := range requests {
+		_, err := c.Write([]byte(s))
+		assert.MustNoError(err)
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	var expect = []string{
+		"+PONG\r\n", "+OK\r\n", "+PONG\r\n", "+PONG\r\n", "+OK\r\n",
+		"-NOPERM this user has no permissions to run the 'keys' command or its subcommand\r\n",
+	}
+	for _, s := range expect {
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		assert.Must(line == s)
+	}
+}
+
+func TestLargeBackendReply(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+	d := redis.NewDecoder(c)
 
-func New(address string) *Server{
-	log.Println("Creating server with address", address)
-	server := &Server{
-		address:address,
+	const n = 1024 * 1024
+	_, err = c.Write([]byte("*2\r\n$3\r\nGET\r\n$7\r\n1048576\r\n*1\r\n$4\r\nPING\r\n"))
+	assert.MustNoError(err)
+
+	resp, err := d.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsBulkBytes() && len(resp.Value) == n)
+	resp, err = d.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsString() && string(resp.Value) == "PONG")
+}
+
+func TestClientPipeline(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	const n = 2000
+	go func() {
+		e := redis.NewEncoder(c)
+		for i := 0; i < n; i++ {
+			multi := []*redis.Resp{
+				redis.NewBulkBytes([]byte("GET")),
+				redis.NewBulkBytes([]byte(strconv.Itoa(i))),
+			}
+			assert.MustNoError(e.EncodeMultiBulk(multi, false))
+		}
+		assert.MustNoError(e.Flush())
+	}()
+
+	d := redis.NewDecoder(c)
+	for i := 0; i < n; i++ {
+		resp, err := d.Decode()
+		assert.MustNoError(err)
+		assert.Must(resp.IsBulkBytes() && len(resp.Value) == i)
 	}
-	return server
 }
 
 /*
-	测试结果：
-	1.不支持redis-benchmark [PING/SET/GET] 单个命令，benchmark中断后代理会自动关闭
-	2.过滤了部分不支持的命令：如 keys
-	3.支持集群模式重连操作
-	4.支持redis单机加密
+	send a request through proxy to backend, PING is replied by proxy
  */
-func Test(t *testing.T) {
-	server := New(address)
-	defer server.Listen()
+func pingProxy(addr string) {
+	c, err := net.Dial("tcp", addr)
+	assert.MustNoError(err)
+	defer c.Close()
+	_, err = c.Write([]byte("SET k v\r\n"))
+	assert.MustNoError(err)
+	line, err := bufio.NewReader(c).ReadString('\n')
+	assert.MustNoError(err)
+	assert.Must(line == "+OK\r\n")
+}
+
+func TestClientDisconnect(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	var requests = []string{
+		"",
+		"*2\r\n$3\r\nGET\r\n",
+		"*2\r\n$3\r\nGET\r\n$7\r\n1048576\r\n",
+		"*x\r\n",
+	}
+	for _, s := range requests {
+		c, err := net.Dial("tcp", server.Addr())
+		assert.MustNoError(err)
+		_, err = c.Write([]byte(s))
+		assert.MustNoError(err)
+		c.Close()
+
+		// proxy stays up for other clients
+		pingProxy(server.Addr())
+	}
+	for server.clients.Len() != 0 {
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestBackendError(t *testing.T) {
+	backend := newFakeRedis()
+
+	server, errc := newTestServer(backend.Addr().String())
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	request := func(s string) string {
+		_, err := c.Write([]byte(s))
+		assert.MustNoError(err)
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		return line
+	}
+
+	// backend connection broken, reconnect on next request
+	assert.Must(strings.HasPrefix(request("CRASH\r\n"), "-ERR "))
+	assert.Must(request("SET k v\r\n") == "+OK\r\n")
+
+	// backend down
+	backend.Close()
+	assert.Must(strings.HasPrefix(request("CRASH\r\n"), "-ERR "))
+	assert.Must(strings.HasPrefix(request("SET k v\r\n"), "-ERR backend unavailable"))
 }
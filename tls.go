@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/errors"
+	"SSAWPROXY/redisProxy/utils/log"
+)
+
+/*
+	TLS termination of client sessions
+	1.certificate & key from tls_cert_file & tls_key_file
+	2.mTLS: client certificates are required and verified by tls_ca_file
+	3.files are checked every tls_reload_period, new handshakes use the reloaded files,
+	  the running files are kept if reloading fails
+	4.handshakes older than tls_min_version are rejected
+	backend connections are wrapped in TLS if backend_tls is true, see newBackendTLSConfig
+ */
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type tlsLoader struct {
+	mu     sync.RWMutex
+	config *Config
+
+	cert    *tls.Certificate
+	clients *x509.CertPool // CAs of client certificates
+	modTime time.Time      // latest modification time of the files
+
+	exit struct {
+		C chan struct{}
+	}
+	closed bool
+}
+
+func newTLSLoader(config *Config) (*tlsLoader, error) {
+	l := &tlsLoader{config: config}
+	l.exit.C = make(chan struct{})
+	if _, err := l.reload(); err != nil {
+		return nil, err
+	}
+	if period := config.TLSReloadPeriod.Get(); period != 0 {
+		go l.loopReload(period)
+	}
+	return l, nil
+}
+
+func (l *tlsLoader) files() []string {
+	files := []string{l.config.TLSCertFile, l.config.TLSKeyFile}
+	if l.config.TLSCAFile != "" {
+		files = append(files, l.config.TLSCAFile)
+	}
+	return files
+}
+
+/*
+	load files if any of them is modified, true if reloaded
+ */
+func (l *tlsLoader) reload() (bool, error) {
+	var modTime time.Time
+	for _, file := range l.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	l.mu.RLock()
+	unchanged := modTime.Equal(l.modTime)
+	l.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.config.TLSCertFile, l.config.TLSKeyFile)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	var clients *x509.CertPool
+	if l.config.TLSCAFile != "" {
+		b, err := os.ReadFile(l.config.TLSCAFile)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		clients = x509.NewCertPool()
+		if !clients.AppendCertsFromPEM(b) {
+			return false, errors.Errorf("no certificate in tls_ca_file %s", l.config.TLSCAFile)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cert, l.clients, l.modTime = &cert, clients, modTime
+	return true, nil
+}
+
+func (l *tlsLoader) loopReload(period time.Duration) {
+	var ticker = time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.exit.C:
+			return
+		case <-ticker.C:
+			reloaded, err := l.reload()
+			if err != nil {
+				log.WarnErrorf(err, "reload tls files failed")
+			} else if reloaded {
+				log.Warnf("reload tls files, cert = %s", l.config.TLSCertFile)
+			}
+		}
+	}
+}
+
+/*
+	tls config of a handshake, with the latest certificate & CAs
+ */
+func (l *tlsLoader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*l.cert},
+		MinVersion:   tlsVersions[l.config.TLSMinVersion],
+	}
+	if l.clients != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = l.clients
+	}
+	return config, nil
+}
+
+func (l *tlsLoader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tlsVersions[l.config.TLSMinVersion],
+		GetConfigForClient: l.configForClient,
+	}
+}
+
+func (l *tlsLoader) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.exit.C)
+}
+
+/*
+	tls config of backend connections, nil if backend_tls is false
+ */
+func newBackendTLSConfig(config *Config) (*tls.Config, error) {
+	if !config.BackendTLS {
+		return nil, nil
+	}
+	c := &tls.Config{
+		ServerName:         config.BackendTLSServerName,
+		InsecureSkipVerify: config.BackendTLSSkipVerify,
+	}
+	if config.BackendTLSCAFile != "" {
+		b, err := os.ReadFile(config.BackendTLSCAFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificate in backend_tls_ca_file %s", config.BackendTLSCAFile)
+		}
+	}
+	if config.BackendTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.BackendTLSCertFile, config.BackendTLSKeyFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
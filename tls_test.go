@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	self-signed certificate for tests, signed by parent if it is not nil
+ */
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	kpem []byte
+}
+
+func newTestCert(name string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.MustNoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{name},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.MustNoError(err)
+	cert, err := x509.ParseCertificate(der)
+	assert.MustNoError(err)
+	kder, err := x509.MarshalECPrivateKey(key)
+	assert.MustNoError(err)
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		kpem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}),
+	}
+}
+
+func (c *testCert) pair() tls.Certificate {
+	pair, err := tls.X509KeyPair(c.pem, c.kpem)
+	assert.MustNoError(err)
+	return pair
+}
+
+func (c *testCert) write(certFile, keyFile string) {
+	assert.MustNoError(os.WriteFile(certFile, c.pem, 0600))
+	assert.MustNoError(os.WriteFile(keyFile, c.kpem, 0600))
+}
+
+func TestServerTLS(t *testing.T) {
+	backend := newFakeRedis()
+	defer backend.Close()
+
+	dir := t.TempDir()
+	ca := newTestCert("ca", 1, nil)
+	assert.MustNoError(os.WriteFile(filepath.Join(dir, "ca.crt"), ca.pem, 0600))
+	newTestCert("proxy", 2, ca).write(filepath.Join(dir, "proxy.crt"), filepath.Join(dir, "proxy.key"))
+
+	config := NewDefaultConfig()
+	config.ProxyAddr = "127.0.0.1:0"
+	config.BackendAddrs = []string{backend.Addr().String()}
+	config.TLSCertFile = filepath.Join(dir, "proxy.crt")
+	config.TLSKeyFile = filepath.Join(dir, "proxy.key")
+	config.TLSCAFile = filepath.Join(dir, "ca.crt")
+	config.TLSMinVersion = "1.2"
+	config.TLSReloadPeriod.Set(0)
+	server, errc := startTestServer(config)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := newTestCert("client", 3, ca).pair()
+
+	// returns subject of server certificate
+	ping := func(config *tls.Config) (string, error) {
+		c, err := tls.Dial("tcp", server.Addr(), config)
+		if err != nil {
+			return "", err
+		}
+		defer c.Close()
+		if _, err := c.Write([]byte("SET k v\r\n")); err != nil {
+			return "", err
+		}
+		line, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		assert.Must(line == "+OK\r\n")
+		return c.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	name, err := ping(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client}})
+	assert.MustNoError(err)
+	assert.Must(name == "proxy")
+
+	// client certificate is required
+	_, err = ping(&tls.Config{RootCAs: roots})
+	assert.Must(err != nil)
+
+	// client certificate signed by unknown CA
+	other := newTestCert("other", 4, newTestCert("other-ca", 5, nil)).pair()
+	_, err = ping(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{other}})
+	assert.Must(err != nil)
+
+	// min version
+	_, err = ping(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client}, MaxVersion: tls.VersionTLS11})
+	assert.Must(err != nil)
+
+	// plaintext
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	_, err = c.Write([]byte("SET k v\r\n"))
+	assert.MustNoError(err)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	assert.Must(line != "+OK\r\n")
+	c.Close()
+
+	// hot reload
+	newTestCert("proxy-renewed", 6, ca).write(config.TLSCertFile, config.TLSKeyFile)
+	future := time.Now().Add(time.Minute)
+	assert.MustNoError(os.Chtimes(config.TLSCertFile, future, future))
+	reloaded, err := server.tls.reload()
+	assert.MustNoError(err)
+	assert.Must(reloaded)
+	name, err = ping(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client}})
+	assert.MustNoError(err)
+	assert.Must(name == "proxy-renewed")
+}
+
+func TestBackendTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert("ca", 1, nil)
+	assert.MustNoError(os.WriteFile(filepath.Join(dir, "ca.crt"), ca.pem, 0600))
+	newTestCert("proxy", 2, ca).write(filepath.Join(dir, "proxy.crt"), filepath.Join(dir, "proxy.key"))
+
+	// redis requires client certificates signed by ca
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	backend := tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{newTestCert("redis.test", 3, ca).pair()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	serveFake(backend, fakeRedis)
+	defer backend.Close()
+
+	request := func(fn func(c *Config)) string {
+		config := NewDefaultConfig()
+		config.ProxyAddr = "127.0.0.1:0"
+		config.BackendAddrs = []string{backend.Addr().String()}
+		config.BackendTLS = true
+		config.BackendTLSCertFile = filepath.Join(dir, "proxy.crt")
+		config.BackendTLSKeyFile = filepath.Join(dir, "proxy.key")
+		fn(config)
+		server, errc := startTestServer(config)
+		defer func() {
+			assert.MustNoError(server.Close())
+			assert.MustNoError(<-errc)
+		}()
+
+		c, err := net.Dial("tcp", server.Addr())
+		assert.MustNoError(err)
+		defer c.Close()
+		_, err = c.Write([]byte("SET k v\r\n"))
+		assert.MustNoError(err)
+		line, err := bufio.NewReader(c).ReadString('\n')
+		assert.MustNoError(err)
+		return line
+	}
+
+	assert.Must(request(func(c *Config) {
+		c.BackendTLSCAFile = filepath.Join(dir, "ca.crt")
+		c.BackendTLSServerName = "redis.test"
+	}) == "+OK\r\n")
+
+	// name doesn't match the certificate
+	assert.Must(strings.HasPrefix(request(func(c *Config) {
+		c.BackendTLSCAFile = filepath.Join(dir, "ca.crt")
+		c.BackendTLSServerName = "other.test"
+	}), "-ERR backend unavailable"))
+
+	// unknown CA
+	assert.Must(strings.HasPrefix(request(func(c *Config) {}), "-ERR backend unavailable"))
+	assert.Must(request(func(c *Config) { c.BackendTLSSkipVerify = true }) == "+OK\r\n")
+
+	// no client certificate
+	assert.Must(strings.HasPrefix(request(func(c *Config) {
+		c.BackendTLSSkipVerify = true
+		c.BackendTLSCertFile, c.BackendTLSKeyFile = "", ""
+	}), "-ERR "))
+}
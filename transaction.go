@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/log"
+)
+
+/*
+	transactions of client sessions
+	1.MULTI starts queueing, commands are checked & queued by proxy and replied +QUEUED
+	2.EXEC sends MULTI, queued commands and EXEC to one backend connection without
+	  other requests in between, only the reply of EXEC is returned
+	3.WATCH pins the session to a dedicated backend connection until EXEC/DISCARD/UNWATCH,
+	  watched keys are kept by that connection, SELECT is rejected meanwhile
+	4.cluster mode: keys of a transaction must hash to the same slot, otherwise -CROSSSLOT
+	5.an error while queueing discards the transaction on EXEC with -EXECABORT,
+	  except nested MULTI and WATCH inside MULTI which are only replied with an error
+	6.replies of transactions are never redirected, -MOVED only updates the slot table
+ */
+
+var transactionCommands = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+	"UNWATCH": true,
+}
+
+/*
+	transaction state of a session, only used by client loopReader
+ */
+type transaction struct {
+	conn *redisConn // pinned by WATCH
+	last *Request   // last request sent on the pinned connection
+
+	key    []byte // first key of the transaction, decides the slot in cluster mode
+	multi  bool
+	dirty  bool
+	queued []*Request
+}
+
+func (client *Client) handleTransaction(r *Request) {
+	txn := &client.txn
+	switch r.OpStr {
+	case "MULTI":
+		if txn.multi {
+			r.Resp = redis.NewErrorf("ERR MULTI calls can not be nested")
+			return
+		}
+		txn.multi = true
+		r.Resp = redis.NewString([]byte("OK"))
+	case "EXEC":
+		if !txn.multi {
+			r.Resp = redis.NewErrorf("ERR EXEC without MULTI")
+			return
+		}
+		client.execTransaction(r)
+	case "DISCARD":
+		if !txn.multi {
+			r.Resp = redis.NewErrorf("ERR DISCARD without MULTI")
+			return
+		}
+		client.resetTransaction()
+		r.Resp = redis.NewString([]byte("OK"))
+	case "WATCH":
+		if txn.multi {
+			r.Resp = redis.NewErrorf("ERR WATCH inside MULTI is not allowed")
+			return
+		}
+		client.watch(r)
+	case "UNWATCH":
+		if txn.multi {
+			client.queue(r)
+			return
+		}
+		client.resetTransaction()
+		r.Resp = redis.NewString([]byte("OK"))
+	default:
+		client.queue(r)
+	}
+}
+
+/*
+	check keys of a request, false if they don't hash to the slot of the transaction
+ */
+func (client *Client) checkSlot(r *Request) bool {
+	if client.server.config.BackendMode != BackendModeCluster {
+		return true
+	}
+	txn := &client.txn
+	for _, key := range getKeys(r.Multi, r.OpStr) {
+		if txn.key == nil {
+			txn.key = key
+			continue
+		}
+		if redis.HashSlot(key) != redis.HashSlot(txn.key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (client *Client) queue(r *Request) {
+	// SELECT会改变共享连接的数据库
+	if pubsubCommands[r.OpStr] || r.OpStr == "SELECT" {
+		r.Resp = redis.NewErrorf("ERR Command not allowed inside a transaction")
+		return
+	}
+	if !client.checkSlot(r) {
+		r.Resp = redis.NewErrorf("CROSSSLOT Keys in request don't hash to the same slot")
+		return
+	}
+	client.txn.queued = append(client.txn.queued, NewRequest(r.Multi, r.OpStr))
+	r.Resp = redis.NewString([]byte("QUEUED"))
+}
+
+func (client *Client) watch(r *Request) {
+	if !client.checkSlot(r) {
+		r.Resp = redis.NewErrorf("CROSSSLOT Keys in request don't hash to the same slot")
+		return
+	}
+	txn := &client.txn
+	addr := client.transactionAddr()
+	if txn.conn != nil && txn.conn.addr != addr {
+		// 槽位已迁移, 原连接上的WATCH没有意义
+		client.releaseConn()
+	}
+	if txn.conn == nil {
+		if addr == "" {
+			r.Resp = redis.NewErrorf("ERR backend unavailable, %s", ErrNoMaster)
+			return
+		}
+		rConn, err := client.server.manager.dial(addr, client.database)
+		if err != nil {
+			log.WarnErrorf(err, "client [%s] pin connection to %s failed", client.RemoteAddr(), addr)
+			r.Resp = redis.NewErrorf("ERR backend unavailable, %s", err)
+			return
+		}
+		txn.conn = rConn
+	}
+	r.Pinned = true
+	txn.conn.PushBack(r)
+	txn.last = r
+}
+
+/*
+	send MULTI, queued commands and EXEC as a whole
+ */
+func (client *Client) execTransaction(r *Request) {
+	txn := &client.txn
+	defer client.resetTransaction()
+
+	if txn.dirty {
+		r.Resp = redis.NewErrorf("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	rConn := txn.conn
+	if rConn == nil {
+		addr := client.transactionAddr()
+		if addr == "" {
+			r.Resp = redis.NewErrorf("ERR backend unavailable, %s", ErrNoMaster)
+			return
+		}
+		var err error
+		if rConn, err = client.server.manager.Get(addr, client.database); err != nil {
+			log.WarnErrorf(err, "client [%s] dispatch EXEC failed", client.RemoteAddr())
+			r.Resp = redis.NewErrorf("ERR backend unavailable, %s", err)
+			return
+		}
+	}
+	multi := NewRequest([]*redis.Resp{redis.NewBulkBytes([]byte("MULTI"))}, "MULTI")
+	r.Txn = append([]*Request{multi}, txn.queued...)
+	r.Pinned = true
+	rConn.pushBack(append(r.Txn, r)...)
+	txn.last = r
+}
+
+/*
+	master of the transaction, node of its slot in cluster mode
+ */
+func (client *Client) transactionAddr() string {
+	router := client.server.router
+	if client.server.config.BackendMode == BackendModeCluster && client.txn.key != nil {
+		return router.lookupKey(client.txn.key)
+	}
+	return router.masterAddr()
+}
+
+/*
+	leave the transaction, the pinned connection is released
+ */
+func (client *Client) resetTransaction() {
+	client.releaseConn()
+	client.txn = transaction{}
+}
+
+/*
+	close the pinned connection once its last request is replied
+ */
+func (client *Client) releaseConn() {
+	txn := &client.txn
+	if txn.conn == nil {
+		return
+	}
+	rConn, last := txn.conn, txn.last
+	txn.conn, txn.last = nil, nil
+	if last == nil {
+		rConn.Close()
+		return
+	}
+	go func() {
+		last.Batch.Wait()
+		rConn.Close()
+	}()
+}
+
+/*
+	reply of a pinned request, waits for MULTI & queued commands sent before it,
+	also used by blocking commands
+ */
+func (router *Router) handleTransaction(r *Request) (*redis.Resp, error) {
+	for _, sub := range append(r.Txn, r) {
+		sub.Batch.Wait()
+		if sub.Err != nil {
+			continue
+		}
+		if ask, slot, addr, ok := parseRedirect(sub.Resp); ok && !ask {
+			router.setSlot(slot, addr)
+			router.triggerRefresh()
+		}
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return r.Resp, nil
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"SSAWPROXY/redisProxy/utils/assert"
+)
+
+/*
+	fake redis with transactions for tests:
+	SET key value -> +OK, GET key -> value, WATCH keys -> +OK,
+	MULTI -> +OK, commands in MULTI -> +QUEUED, EXEC -> replies or nil if a watched key is modified
+ */
+func newFakeTxRedis() net.Listener {
+	var mu sync.Mutex
+	var values = make(map[string]string)
+	var versions = make(map[string]int)
+	handle := func(multi []string) string {
+		switch strings.ToUpper(multi[0]) {
+		case "SET":
+			values[multi[1]] = multi[2]
+			versions[multi[1]]++
+			return "+OK\r\n"
+		case "GET":
+			if v, ok := values[multi[1]]; ok {
+				return fakeBulk(v)
+			}
+			return "$-1\r\n"
+		case "PING":
+			return "+PONG\r\n"
+		}
+		return "-ERR unknown command '" + multi[0] + "'\r\n"
+	}
+	return newFakeServer(func() fakeHandler {
+		var watched = make(map[string]int)
+		var queued [][]string
+		var multi bool
+		return func(c net.Conn, args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			switch op := strings.ToUpper(args[0]); {
+			case op == "WATCH":
+				for _, key := range args[1:] {
+					watched[key] = versions[key]
+				}
+				return "+OK\r\n"
+			case op == "MULTI":
+				multi = true
+				return "+OK\r\n"
+			case op == "EXEC":
+				var aborted bool
+				for key, version := range watched {
+					aborted = aborted || versions[key] != version
+				}
+				var b = "*-1\r\n"
+				if !aborted {
+					b = "*" + strconv.Itoa(len(queued)) + "\r\n"
+					for _, args := range queued {
+						b += handle(args)
+					}
+				}
+				watched, queued, multi = make(map[string]int), nil, false
+				return b
+			case multi:
+				queued = append(queued, args)
+				return "+QUEUED\r\n"
+			}
+			return handle(args)
+		}
+	})
+}
+
+func TestClientTransaction(t *testing.T) {
+	backend := newFakeTxRedis()
+	defer backend.Close()
+
+	server, errc := newTestServer(backend.Addr().String())
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c1 := dialTestConn(server.Addr())
+	defer c1.Close()
+	c2 := dialTestConn(server.Addr())
+	defer c2.Close()
+
+	expectString(c1.do("EXEC"), "ERR EXEC without MULTI")
+	expectString(c1.do("DISCARD"), "ERR DISCARD without MULTI")
+
+	// queued by proxy, sent on EXEC
+	expectString(c1.do("MULTI"), "OK")
+	// errors while queueing discard the transaction
+	expectString(c1.do("GET"), "ERR wrong number of arguments for 'get' command")
+	expectString(c1.do("SET", "k", "1"), "QUEUED")
+	expectString(c2.do("SET", "k", "0"), "OK")
+	resp := c1.do("EXEC")
+	assert.Must(resp.IsError() && strings.HasPrefix(string(resp.Value), "EXECABORT"))
+	expectString(c2.do("GET", "k"), "0")
+
+	// nested MULTI and WATCH inside MULTI keep the transaction as redis does
+	expectString(c1.do("MULTI"), "OK")
+	expectString(c1.do("MULTI"), "ERR MULTI calls can not be nested")
+	expectString(c1.do("SET", "k", "1"), "QUEUED")
+	expectString(c1.do("WATCH", "k"), "ERR WATCH inside MULTI is not allowed")
+	expectString(c1.do("GET", "k"), "QUEUED")
+	resp = c1.do("EXEC")
+	assert.Must(resp.IsArray() && len(resp.Array) == 2)
+	expectString(resp.Array[0], "OK")
+	expectString(resp.Array[1], "1")
+
+	// optimistic lock: modified watched key fails EXEC
+	expectString(c1.do("WATCH", "k"), "OK")
+	expectString(c2.do("SET", "k", "2"), "OK")
+	expectString(c1.do("MULTI"), "OK")
+	expectString(c1.do("WATCH", "k"), "ERR WATCH inside MULTI is not allowed")
+	expectString(c1.do("DISCARD"), "OK")
+
+	expectString(c1.do("WATCH", "k"), "OK")
+	expectString(c1.do("MULTI"), "OK")
+	expectString(c1.do("SET", "k", "3"), "QUEUED")
+	expectString(c2.do("SET", "k", "4"), "OK")
+	resp = c1.do("EXEC")
+	assert.Must(resp.IsArray() && resp.Array == nil)
+	expectString(c2.do("GET", "k"), "4")
+
+	expectString(c1.do("WATCH", "k"), "OK")
+	expectString(c1.do("MULTI"), "OK")
+	expectString(c1.do("SET", "k", "5"), "QUEUED")
+	resp = c1.do("EXEC")
+	assert.Must(resp.IsArray() && len(resp.Array) == 1)
+	expectString(c2.do("GET", "k"), "5")
+
+	// the pinned connection keeps its database, SELECT waits for UNWATCH
+	expectString(c1.do("WATCH", "k"), "OK")
+	expectString(c1.do("SELECT", "1"), "ERR SELECT is not allowed after WATCH, UNWATCH first")
+	expectString(c1.do("UNWATCH"), "OK")
+	expectString(c1.do("SELECT", "1"), "OK")
+}
+
+func TestClusterTransaction(t *testing.T) {
+	fc := newFakeCluster(2)
+	defer fc.Close()
+
+	server, errc := newTestClusterServer(fc)
+	defer func() {
+		assert.MustNoError(server.Close())
+		assert.MustNoError(<-errc)
+	}()
+
+	c, err := net.Dial("tcp", server.Addr())
+	assert.MustNoError(err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	var tests = []struct {
+		request []string
+		reply   string
+	}{
+		{[]string{"WATCH", "a", "b"}, "-CROSSSLOT Keys in request don't hash to the same slot\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"GET", "{a}1"}, "+QUEUED\r\n"},
+		{[]string{"MSET", "{a}2", "1", "b", "2"}, "-CROSSSLOT Keys in request don't hash to the same slot\r\n"},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+	}
+	for _, test := range tests {
+		_, err := c.Write(encodeTestRequest(test.request...))
+		assert.MustNoError(err)
+		line, err := r.ReadString('\n')
+		assert.MustNoError(err)
+		assert.Must(line == test.reply)
+	}
+}
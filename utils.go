@@ -8,6 +8,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+
+	"SSAWPROXY/redisProxy/proxy/redis"
+	"SSAWPROXY/redisProxy/utils/errors"
 )
 
 /*
@@ -17,6 +20,7 @@ import (
 	4.convert interface to string
 	5.parse send message to lots of package
 	6.兼容单机auth
+	7.extract command name from request
  */
 
 
@@ -174,32 +178,31 @@ func (utils *utils)Int64ToBytes(i int64) []byte{
 }
 
 /*
-	兼容集群模式
-	判断从redis接收到的返回数据是否为 "-ERR MOVED slot IP"
-	重新新连接与指定的ip相连
+	兼容单机加密
  */
-func (utils *utils) cluster(b []byte) string {
-	if b[0] == 45 && string(b[1:6]) == "MOVED" {
-		return strings.Fields(string(b))[2]
-	}else {
-		return ""
+func (utils *utils) auth(auth string) []*redis.Resp {
+	return []*redis.Resp{
+		redis.NewBulkBytes([]byte("AUTH")),
+		redis.NewBulkBytes([]byte(auth)),
 	}
 }
 
+var (
+	ErrBadMultiBulk = errors.New("bad multi-bulk for command")
+	ErrBadOpStrLen  = errors.New("bad command length, too short or too long")
+)
+
 /*
-	兼容单机加密
+	提取命令名称(大写), 如：SET, GET, LPOP
+	params: multi bulk request decoded from client
  */
-func (utils *utils) auth(auth string) []byte {
-	charset := []byte("\r\n")
-	// 组装消息
-	result := [][]byte{
-		[]byte("*2"),
-		[]byte("$4"),
-		[]byte("AUTH"),
-		[]byte("$" + strconv.Itoa(len(auth))),
-		[]byte(auth),
-		[]byte(""),
+func (utils *utils) opStr(multi []*redis.Resp) (string, error) {
+	if len(multi) == 0 || !multi[0].IsBulkBytes() {
+		return "", ErrBadMultiBulk
 	}
-	res := bytes.Join(result, charset)
-	return res
+	op := multi[0].Value
+	if len(op) == 0 || len(op) > 64 {
+		return "", ErrBadOpStrLen
+	}
+	return strings.ToUpper(string(op)), nil
 }
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"container/list"
+	"crypto/tls"
 	"net"
 	"strconv"
 	"strings"
@@ -43,11 +44,24 @@ func NewClientNoAuth(addr string, timeout time.Duration) (*Client, error) {
 	return: *Client, error
  */
 func NewClient(addr string, auth string, timeout time.Duration) (*Client, error) {
-	c, err := redigo.Dial("tcp", addr, []redigo.DialOption{
+	return NewClientTLS(addr, auth, timeout, nil)
+}
+
+/*
+	新建基于TLS的redis client, config为nil时使用明文连接
+	params: address, auth(可选), timeout, tls config
+	return: *Client, error
+ */
+func NewClientTLS(addr string, auth string, timeout time.Duration, config *tls.Config) (*Client, error) {
+	options := []redigo.DialOption{
 		redigo.DialConnectTimeout(math2.MinDuration(time.Second, timeout)),
 		redigo.DialPassword(auth),
 		redigo.DialReadTimeout(timeout), redigo.DialWriteTimeout(timeout),
-	}...)
+	}
+	if config != nil {
+		options = append(options, redigo.DialUseTLS(true), redigo.DialTLSConfig(config))
+	}
+	c, err := redigo.Dial("tcp", addr, options...)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
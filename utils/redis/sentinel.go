@@ -4,6 +4,7 @@
 package redis
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strconv"
@@ -22,6 +23,8 @@ type Sentinel struct {
 
 	Product, Auth string
 
+	TLSConfig *tls.Config // nil means plaintext
+
 	LogFunc func(format string, arguments ...interface{})
 	ErrFunc func(err error, format string, arguments ...interface{})
 }
@@ -52,6 +55,13 @@ func (s *Sentinel) isSameProduct(name string) bool {
 	return strings.HasPrefix(name, s.Product)
 }
 
+// +switch-master payload: <master name> <old ip> <old port> <new ip> <new port>
+// the master name must be Product exactly, other masters of the sentinels are ignored
+func (s *Sentinel) isSameMaster(payload string) bool {
+	fields := strings.Fields(payload)
+	return len(fields) != 0 && fields[0] == s.Product
+}
+
 func (s *Sentinel) printf(format string, arguments ...interface{}) {
 	if s.LogFunc != nil {
 		s.LogFunc(format, arguments...)
@@ -102,7 +112,7 @@ func (s *Sentinel) subscribeCommand(client *Client, sentinel string, onSubscribe
 			if len(message) != 3 {
 				return errors.Errorf("invalid response = %v", values)
 			}
-			if s.isSameProduct(message[2]) {
+			if s.isSameMaster(message[2]) {
 				return nil
 			}
 		}
@@ -110,7 +120,7 @@ func (s *Sentinel) subscribeCommand(client *Client, sentinel string, onSubscribe
 }
 
 func (s *Sentinel) subscribeInstance(ctx context.Context, sentinel string, timeout time.Duration, onSubscribed func(string)) (bool, error) {
-	c, err := NewClientNoAuth(sentinel, timeout)
+	c, err := NewClientTLS(sentinel, "", timeout, s.TLSConfig)
 	if err != nil {
 		return false, err
 	}
@@ -244,7 +254,7 @@ func (s *Sentinel) mastersCommand(client *Client) ([]map[string]string, error) {
 }
 
 func (s *Sentinel) mastersInstance(ctx context.Context, sentinel string, groups map[int]bool, timeout time.Duration) (map[int]*SentinelMaster, error) {
-	c, err := NewClientNoAuth(sentinel, timeout)
+	c, err := NewClientTLS(sentinel, "", timeout, s.TLSConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -364,6 +374,100 @@ func (s *Sentinel) Masters(groups map[int]bool, timeout time.Duration, sentinels
 	}
 }
 
+func (s *Sentinel) masterInstance(ctx context.Context, sentinel string, name string, timeout time.Duration) (*SentinelMaster, error) {
+	c, err := NewClientTLS(sentinel, "", timeout, s.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var exit = make(chan error, 1)
+	var master *SentinelMaster
+
+	go func() (err error) {
+		defer func() {
+			exit <- err
+		}()
+		m, err := s.masterCommand(c, name)
+		if err != nil {
+			return err
+		} else if m == nil {
+			return errors.Errorf("master %s is not monitored", name)
+		}
+		epoch, err := strconv.ParseInt(m["config-epoch"], 10, 64)
+		if err != nil {
+			return errors.Errorf("parse config-epoch failed, %s", err)
+		}
+		ip, port := m["ip"], m["port"]
+		if ip == "" || port == "" {
+			return errors.Errorf("parse ip:port failed, '%s:%s'", ip, port)
+		}
+		master = &SentinelMaster{
+			Addr: net.JoinHostPort(ip, port),
+			Info: m, Epoch: epoch,
+		}
+		return nil
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case err := <-exit:
+		if err != nil {
+			return nil, err
+		}
+		return master, nil
+	}
+}
+
+/*
+	address of master name voted by the majority of sentinels, the newest config-epoch wins
+ */
+func (s *Sentinel) Master(name string, timeout time.Duration, sentinels ...string) (string, error) {
+	cntx, cancel := context.WithTimeout(s.Context, timeout)
+	defer cancel()
+
+	timeout += time.Second * 5
+	results := make(chan *SentinelMaster, len(sentinels))
+
+	var majority = 1 + len(sentinels)/2
+
+	for i := range sentinels {
+		go func(sentinel string) {
+			master, err := s.masterInstance(cntx, sentinel, name, timeout)
+			if err != nil {
+				s.errorf(err, "sentinel-[%s] master %s failed", sentinel, name)
+			}
+			results <- master
+		}(sentinels[i])
+	}
+
+	var current *SentinelMaster
+	var voted int
+	for alive := len(sentinels); alive != 0; alive-- {
+		select {
+		case <-cntx.Done():
+			alive = 1
+		case m := <-results:
+			if m == nil {
+				continue
+			}
+			if current == nil || current.Epoch < m.Epoch {
+				current = m
+			}
+			voted += 1
+		}
+	}
+	if cntx.Err() == context.Canceled {
+		s.printf("sentinel master %s canceled (%v)", name, cntx.Err())
+		return "", cntx.Err()
+	}
+	if voted < majority {
+		return "", errors.Errorf("lost majority (%d/%d)", voted, len(sentinels))
+	}
+	return current.Addr, nil
+}
+
 type MonitorConfig struct {
 	Quorum          int
 	ParallelSyncs   int
@@ -420,7 +524,7 @@ func (s *Sentinel) monitorCommand(client *Client, sentniel string, groups map[in
 }
 
 func (s *Sentinel) monitorInstance(ctx context.Context, sentinel string, groups map[int]*net.TCPAddr, config *MonitorConfig, timeout time.Duration) error {
-	c, err := NewClientNoAuth(sentinel, timeout)
+	c, err := NewClientTLS(sentinel, "", timeout, s.TLSConfig)
 	if err != nil {
 		return err
 	}
@@ -526,7 +630,7 @@ func (s *Sentinel) unmonitorCommand(client *Client, sentinel string, groups map[
 }
 
 func (s *Sentinel) unmonitorInstance(ctx context.Context, sentinel string, groups map[int]bool, timeout time.Duration) error {
-	c, err := NewClientNoAuth(sentinel, timeout)
+	c, err := NewClientTLS(sentinel, "", timeout, s.TLSConfig)
 	if err != nil {
 		return err
 	}
@@ -586,7 +690,7 @@ type SentinelGroup struct {
 }
 
 func (s *Sentinel) MastersAndSlaves(sentinel string, timeout time.Duration) (map[string]*SentinelGroup, error) {
-	c, err := NewClientNoAuth(sentinel, timeout)
+	c, err := NewClientTLS(sentinel, "", timeout, s.TLSConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -612,7 +716,7 @@ func (s *Sentinel) MastersAndSlaves(sentinel string, timeout time.Duration) (map
 }
 
 func (s *Sentinel) FlushConfig(sentinel string) error {
-	c, err := NewClientNoAuth(sentinel, time.Second)
+	c, err := NewClientTLS(sentinel, "", time.Second, s.TLSConfig)
 	if err != nil {
 		return err
 	}
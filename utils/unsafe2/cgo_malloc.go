@@ -1,3 +1,6 @@
+//go:build !cgo_jemalloc
+// +build !cgo_jemalloc
+
 package unsafe2
 
 /*
@@ -1,8 +1,21 @@
-package utils
+//go:build linux
+// +build linux
 
+package utils
 
+// #include <unistd.h>
 import "C"
 
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"SSAWPROXY/redisProxy/utils/errors"
+)
+
 type Usage struct {
 	Utime  time.Duration `json:"utime"`
 	Stime  time.Duration `json:"stime"`
@@ -1,9 +1,15 @@
+//go:build !linux
+// +build !linux
+
 package utils
 
+// #include <unistd.h>
 import "C"
+
 import (
-	"time"
 	"syscall"
+	"time"
+
 	"SSAWPROXY/redisProxy/utils/errors"
 )
 